@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	ackEmoji = "✅"
+	// Delivered reminders may be acknowledged for this long.
+	ackWindow = day
+)
+
+// ackReminders is whether delivered reminders get a reaction to
+// acknowledge them with, set with REMINDME_ACK.
+var ackReminders bool
+
+// A pendingAck is a delivered reminder awaiting acknowledgement.
+type pendingAck struct {
+	userID    string
+	id        string
+	daily     string
+	delivered time.Time
+}
+
+// awaitAck reacts to msg, in which r was delivered, for r's user to
+// acknowledge it, if ackReminders is set. Acknowledgements older than
+// ackWindow are forgotten.
+func (rs *remindmeState) awaitAck(msg *discordgo.Message, r *reminder) {
+	if !ackReminders || msg == nil {
+		return
+	}
+	err := rs.Session().MessageReactionAdd(msg.ChannelID, msg.ID, ackEmoji)
+	if err != nil {
+		logger.User(r.userID).Errorf("unable to add acknowledgement reaction to reminder %s for %s: %v",
+			r.id, r.userID, err)
+		return
+	}
+	now := rs.clock.Now()
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.acks == nil {
+		rs.acks = make(map[string]pendingAck)
+	}
+	for messageID, a := range rs.acks {
+		if now.Sub(a.delivered) > ackWindow {
+			delete(rs.acks, messageID)
+		}
+	}
+	rs.acks[msg.ID] = pendingAck{
+		userID:    r.userID,
+		id:        r.id,
+		daily:     r.daily,
+		delivered: now,
+	}
+}
+
+// ackHandler records the acknowledgement of a delivered reminder by its
+// user.
+func ackHandler(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	if m.Emoji.Name != ackEmoji || m.UserID == s.State.User.ID {
+		return
+	}
+	rmState.Lock()
+	a, ok := rmState.acks[m.MessageID]
+	if ok && a.userID == m.UserID {
+		delete(rmState.acks, m.MessageID)
+	}
+	rmState.Unlock()
+	if !ok || a.userID != m.UserID {
+		return
+	}
+	if a.daily != "" {
+		logger.User(a.userID).Infof("Completed daily reminder %s for %s at %s", a.id, a.userID, a.daily)
+		return
+	}
+	logger.User(a.userID).Infof("Acknowledged reminder %s for %s", a.id, a.userID)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultHTTPAddr  = ":6767"
+	adminTokenHeader = "X-Admin-Token"
+
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// adminToken must be sent in the adminTokenHeader of requests to the
+// administrative endpoints. If it is empty, those endpoints are disabled.
+var adminToken string
+
+func authorized(req *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+	token := req.Header.Get(adminTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+type reminderJSON struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userID"`
+	AuthorID   string    `json:"authorID"`
+	Creation   time.Time `json:"creation"`
+	Expiration time.Time `json:"expiration"`
+	Message    string    `json:"message"`
+}
+
+func newReminderJSON(r *reminder) reminderJSON {
+	return reminderJSON{
+		ID:         r.id,
+		UserID:     r.userID,
+		AuthorID:   r.authorID,
+		Creation:   r.creation,
+		Expiration: r.expiration,
+		Message:    r.message,
+	}
+}
+
+// queryInt returns the integer query parameter key of req, or def if it is
+// absent.
+func queryInt(req *http.Request, key string, def int) (int, error) {
+	v := req.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// remindersHandler lists reminders on GET and creates one on POST.
+func remindersHandler(w http.ResponseWriter, req *http.Request) {
+	if !authorized(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		listRemindersHandler(w, req)
+	case http.MethodPost:
+		createReminderHandler(w, req)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listRemindersHandler serves a page of all current reminders as JSON. The
+// page is selected with the offset and limit query parameters.
+func listRemindersHandler(w http.ResponseWriter, req *http.Request) {
+	offset, err := queryInt(req, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(req, "limit", defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	var page struct {
+		Total     int            `json:"total"`
+		Offset    int            `json:"offset"`
+		Reminders []reminderJSON `json:"reminders"`
+	}
+	page.Offset = offset
+	rmState.Lock()
+	page.Total = len(rmState.reminders)
+	if offset < len(rmState.reminders) {
+		end := offset + limit
+		if end > len(rmState.reminders) {
+			end = len(rmState.reminders)
+		}
+		page.Reminders = make([]reminderJSON, 0, end-offset)
+		for _, r := range rmState.reminders[offset:end] {
+			page.Reminders = append(page.Reminders, newReminderJSON(r))
+		}
+	}
+	rmState.Unlock()
+	if page.Reminders == nil {
+		page.Reminders = []reminderJSON{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&page)
+	if err != nil {
+		logger.Error("writing reminders response: ", err)
+	}
+}
+
+// newReminderRequest is the body of a request to create a reminder. It goes
+// off after duration, written as for the bot's commands, or at expiration,
+// but not both.
+type newReminderRequest struct {
+	UserID     string    `json:"userID"`
+	Duration   string    `json:"duration"`
+	Expiration time.Time `json:"expiration"`
+	Message    string    `json:"message"`
+}
+
+// parse validates nr and returns the reminder it describes.
+func (nr *newReminderRequest) parse(now time.Time) (*reminder, error) {
+	if !snowflakeRe.MatchString(nr.UserID) {
+		return nil, fmt.Errorf("invalid userID")
+	}
+	message := strings.TrimSpace(nr.Message)
+	if blankMessage(strings.Fields(message)) {
+		return nil, fmt.Errorf("missing message")
+	}
+	if n := utf8.RuneCountInString(message); n > maxReminderLen {
+		return nil, fmt.Errorf("message is %d characters long, but may be at most %d", n, maxReminderLen)
+	}
+	var d time.Duration
+	switch {
+	case nr.Duration != "" && !nr.Expiration.IsZero():
+		return nil, fmt.Errorf("give either duration or expiration, not both")
+	case nr.Duration != "":
+		var err error
+		d, err = parseDuration(nr.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %v", err)
+		}
+	case !nr.Expiration.IsZero():
+		d = nr.Expiration.Sub(now)
+	default:
+		return nil, fmt.Errorf("missing duration or expiration")
+	}
+	if err := checkDuration(d); err != nil {
+		return nil, err
+	}
+	return &reminder{
+		userID:     nr.UserID,
+		authorID:   nr.UserID,
+		creation:   now.In(time.UTC),
+		expiration: now.Add(d).In(time.UTC),
+		message:    message,
+	}, nil
+}
+
+// createReminderHandler sets a reminder described by a newReminderRequest
+// and serves it as JSON. The reminder counts as set by its user for
+// themselves.
+func createReminderHandler(w http.ResponseWriter, req *http.Request) {
+	var nr newReminderRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&nr)
+	if err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	r, err := nr.parse(rmState.clock.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reloadLock.RLock()
+	err = rmState.Add(r, maxReminders)
+	reloadLock.RUnlock()
+	switch err {
+	case nil:
+	case errTooManyReminders:
+		http.Error(w, fmt.Sprintf("user already has the maximum of %d reminders", maxReminders),
+			http.StatusConflict)
+		return
+	case errDuplicate:
+		http.Error(w, "user already has that reminder", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	logger.User(r.userID).Infof("Set reminder %s for %s through the API to go off %s with the message %q",
+		r.id, r.userID, r.expiration, r.message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(newReminderJSON(r))
+	if err != nil {
+		logger.Error("writing created reminder response: ", err)
+	}
+}
+
+// healthzHandler reports whether the bot is connected to Discord and how
+// many reminders it has loaded. Unlike the other endpoints, it needs no
+// token, so that process managers can poll it.
+func healthzHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var health struct {
+		Connected bool `json:"connected"`
+		Reminders int  `json:"reminders"`
+	}
+	health.Connected = gateway.connected()
+	rmState.Lock()
+	health.Reminders = len(rmState.reminders)
+	rmState.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&health)
+	if err != nil {
+		logger.Error("writing health response: ", err)
+	}
+}
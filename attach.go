@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// A reminder may carry at most this many attachments.
+const maxAttachments = 5
+
+// imageExts are the extensions of the URLs shown as the image of a reminder
+// embed.
+var imageExts = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+}
+
+// checkAttachment checks that s is an absolute http or https URL.
+func checkAttachment(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid attachment %q; attach links starting with https://", s)
+	}
+	return nil
+}
+
+// isImage reports whether the URL s looks like that of an image.
+func isImage(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && imageExts[strings.ToLower(path.Ext(u.Path))]
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// broadcastInterval is the pause between the messages of a broadcast, to
+// stay well clear of Discord's rate limits.
+const broadcastInterval = time.Second
+
+// ownerID is the user allowed to broadcast, set with REMINDME_OWNER. If it
+// is empty, nobody is.
+var ownerID string
+
+// broadcast sends content privately to every user with a reminder, one at
+// a time, and returns how many it reached out of how many it tried. It
+// stops early when shutting down.
+func broadcast(s *discordgo.Session, content string) (sent, users int) {
+	var userIDs []string
+	rmState.Lock()
+	for userID := range rmState.byUser {
+		userIDs = append(userIDs, userID)
+	}
+	rmState.Unlock()
+	for k, userID := range userIDs {
+		if k > 0 {
+			select {
+			case <-time.After(broadcastInterval):
+			case <-rmState.done:
+				logger.Infof("Interrupted broadcast after %d of %d users", k, len(userIDs))
+				return sent, k
+			}
+		}
+		dm, err := s.UserChannelCreate(userID)
+		if err == nil {
+			_, err = sendChunks(s, dm.ID, content, noMentions)
+		}
+		if err != nil {
+			logger.User(userID).Errorf("unable to broadcast to %s: %v", userID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, len(userIDs)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+const channelsFilename = "channels.csv"
+
+// DeliveryChannel returns the channel userID's reminders are posted in
+// instead of being sent privately, or the empty string if none is set.
+func (rs *remindmeState) DeliveryChannel(userID string) string {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.channels[userID]
+}
+
+// SetDeliveryChannel sets the channel userID's reminders are posted in. An
+// empty channelID has them sent privately again.
+func (rs *remindmeState) SetDeliveryChannel(userID string, channelID string) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.setDeliveryChannel(userID, channelID)
+	rs.appendJournal("channel", userID, channelID)
+	logger.User(userID).Infof("Set delivery channel for %s to %q", userID, channelID)
+}
+
+// setDeliveryChannel is SetDeliveryChannel without journaling.
+// The lock must be held.
+func (rs *remindmeState) setDeliveryChannel(userID string, channelID string) {
+	if channelID == "" {
+		delete(rs.channels, userID)
+		return
+	}
+	if rs.channels == nil {
+		rs.channels = make(map[string]string)
+	}
+	rs.channels[userID] = channelID
+}
+
+func (rs *remindmeState) readChannels(r io.Reader) error {
+	rr := csv.NewReader(r)
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("invalid channel record: %s", record)
+		}
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	for _, record := range records {
+		rs.setDeliveryChannel(record[0], record[1])
+	}
+	return nil
+}
+
+func (rs *remindmeState) writeChannels(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for userID, channelID := range rs.channels {
+		ww.Write([]string{userID, channelID})
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
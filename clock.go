@@ -0,0 +1,45 @@
+package main
+
+import "time"
+
+// A Timer is a timer started by a Clock, like a *time.Timer.
+type Timer interface {
+	// Stop stops the timer, reporting whether it had not gone off yet.
+	Stop() bool
+}
+
+// A Clock tells remindmeState the time and starts the timers that deliver
+// reminders, so that both can be replaced by a clock that does not follow
+// real time, as in tests.
+type Clock interface {
+	// Now returns the current time, like time.Now.
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d, like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// realClock is the Clock of real time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// now returns the current time of rs's clock in UTC.
+func (rs *remindmeState) now() time.Time {
+	return rs.clock.Now().In(time.UTC)
+}
+
+// until is like time.Until by rs's clock.
+func (rs *remindmeState) until(t time.Time) time.Duration {
+	return t.Sub(rs.clock.Now())
+}
+
+// since is like time.Since by rs's clock.
+func (rs *remindmeState) since(t time.Time) time.Duration {
+	return rs.clock.Now().Sub(t)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeClock is a Clock whose time only moves when told to, firing the
+// timers that come due as it does.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// A fakeTimer is a timer started by a fakeClock.
+type fakeTimer struct {
+	clock   *fakeClock
+	when    time.Time
+	f       func()
+	stopped bool
+	fired   bool
+}
+
+// fakeEpoch is when a new fakeClock starts.
+var fakeEpoch = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: fakeEpoch}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc starts a timer calling f once the clock has been advanced by d.
+// Unlike time.AfterFunc, f is not called right away even if d is not
+// positive, but only on the next Advance.
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := !t.stopped && !t.fired
+	t.stopped = true
+	return active
+}
+
+// Advance moves the clock forward by d, then calls the functions of the
+// timers that came due, earliest first, in the calling goroutine.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.stopped && !t.fired && !t.when.After(c.now) {
+			t.fired = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	sort.SliceStable(due, func(a, b int) bool {
+		return due[a].when.Before(due[b].when)
+	})
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// active returns the number of timers that are neither stopped nor fired.
+func (c *fakeClock) active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.stopped && !t.fired {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	var fired []string
+	c.AfterFunc(2*time.Minute, func() { fired = append(fired, "b") })
+	c.AfterFunc(time.Minute, func() { fired = append(fired, "a") })
+	stopped := c.AfterFunc(time.Minute, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() {
+		t.Error("Stop of an active timer returned false")
+	}
+	if stopped.Stop() {
+		t.Error("Stop of a stopped timer returned true")
+	}
+	c.Advance(59 * time.Second)
+	if len(fired) != 0 {
+		t.Fatalf("timers fired early: %v", fired)
+	}
+	c.Advance(2 * time.Minute)
+	if len(fired) != 2 || fired[0] != "a" || fired[1] != "b" {
+		t.Fatalf("fired %v, want [a b]", fired)
+	}
+	if got, want := c.Now(), fakeEpoch.Add(179*time.Second); !got.Equal(want) {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+	if n := c.active(); n != 0 {
+		t.Errorf("%d timers still active", n)
+	}
+}
+
+func TestStateClock(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	c.Advance(time.Hour)
+	if got, want := rs.now(), fakeEpoch.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("now() = %v, want %v", got, want)
+	}
+	if got := rs.until(fakeEpoch.Add(90 * time.Minute)); got != 30*time.Minute {
+		t.Errorf("until = %v, want 30m", got)
+	}
+	if got := rs.since(fakeEpoch); got != time.Hour {
+		t.Errorf("since = %v, want 1h", got)
+	}
+}
+
+func TestTriggerTimeout(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	r := &reminder{userID: "u", authorID: "u", creation: c.Now(), message: "reply"}
+	err := rs.AddTrigger("m", time.Hour, r, defaultMaxReminders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(replyWindow - time.Second)
+	if _, ok := rs.triggers["m"]; !ok {
+		t.Fatal("trigger dropped before the reply window ended")
+	}
+	c.Advance(time.Second)
+	if _, ok := rs.triggers["m"]; ok {
+		t.Fatal("trigger kept after the reply window ended")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A Config holds the settings of the bot. Each may be set in the config
+// file named by REMINDME_CONFIG, under its name in configSettings, and by
+// the environment variable named after it, uppercased and with the
+// REMINDME_ prefix: max_reminders by REMINDME_MAX_REMINDERS. The
+// environment overrides the file, which overrides the defaults, and empty
+// values are ignored. Nothing is written back to the environment, so that
+// admin_token and the like stay out of it.
+type Config struct {
+	LogDir          string
+	RemindersDir    string
+	LogFormat       string
+	MaxReminders    int
+	MaxMessageLen   int
+	ShutdownTimeout time.Duration
+	DedupWindow     time.Duration
+	ReplyWindow     time.Duration
+	RateInterval    time.Duration
+	RateBurst       int
+	DeliveryFormat  string
+	DB              string
+	KeepFiles       int
+	FileRetention   time.Duration
+	Confirm         bool
+	Embeds          bool
+	Ack             bool
+	Owner           string
+	AdminToken      string
+	HTTPAddr        string
+	TokenFile       string
+
+	// deliveryTemplate is DeliveryFormat parsed by validate.
+	deliveryTemplate *template.Template
+}
+
+// defaultConfig returns the Config of a bot with nothing set.
+func defaultConfig() Config {
+	return Config{
+		LogDir:          "log/",
+		RemindersDir:    "reminders/",
+		LogFormat:       "text",
+		MaxReminders:    defaultMaxReminders,
+		MaxMessageLen:   defaultMaxReminderLen,
+		ShutdownTimeout: defaultShutdownTimeout,
+		DedupWindow:     defaultDedupWindow,
+		ReplyWindow:     defaultReplyWindow,
+		RateInterval:    defaultRateInterval,
+		RateBurst:       defaultRateBurst,
+		KeepFiles:       defaultKeepFiles,
+		FileRetention:   defaultFileRetention,
+		HTTPAddr:        defaultHTTPAddr,
+	}
+}
+
+// A configSetting is a setting of a Config, which set parses from its
+// value as a string.
+type configSetting struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+// configSettings are the settings a Config is made of.
+var configSettings = []configSetting{
+	{"log_dir", stringSetting(func(c *Config) *string { return &c.LogDir })},
+	{"reminders_dir", stringSetting(func(c *Config) *string { return &c.RemindersDir })},
+	{"log_format", stringSetting(func(c *Config) *string { return &c.LogFormat })},
+	{"max_reminders", intSetting(func(c *Config) *int { return &c.MaxReminders })},
+	{"max_message_len", intSetting(func(c *Config) *int { return &c.MaxMessageLen })},
+	{"shutdown_timeout", durationSetting(time.ParseDuration, func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"dedup_window", durationSetting(time.ParseDuration, func(c *Config) *time.Duration { return &c.DedupWindow })},
+	{"reply_window", durationSetting(parseDuration, func(c *Config) *time.Duration { return &c.ReplyWindow })},
+	{"rate_interval", durationSetting(time.ParseDuration, func(c *Config) *time.Duration { return &c.RateInterval })},
+	{"rate_burst", intSetting(func(c *Config) *int { return &c.RateBurst })},
+	{"delivery_format", stringSetting(func(c *Config) *string { return &c.DeliveryFormat })},
+	{"db", stringSetting(func(c *Config) *string { return &c.DB })},
+	{"keep_files", intSetting(func(c *Config) *int { return &c.KeepFiles })},
+	{"file_retention", durationSetting(parseDuration, func(c *Config) *time.Duration { return &c.FileRetention })},
+	{"confirm", boolSetting(func(c *Config) *bool { return &c.Confirm })},
+	{"embeds", boolSetting(func(c *Config) *bool { return &c.Embeds })},
+	{"ack", boolSetting(func(c *Config) *bool { return &c.Ack })},
+	{"owner", stringSetting(func(c *Config) *string { return &c.Owner })},
+	{"admin_token", stringSetting(func(c *Config) *string { return &c.AdminToken })},
+	{"http_addr", stringSetting(func(c *Config) *string { return &c.HTTPAddr })},
+	{"token_file", stringSetting(func(c *Config) *string { return &c.TokenFile })},
+}
+
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intSetting(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolSetting(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationSetting(parse func(string) (time.Duration, error), field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := parse(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// envName returns the environment variable for the setting name.
+func envName(name string) string {
+	return "REMINDME_" + strings.ToUpper(name)
+}
+
+// loadConfig returns the defaults overridden by the config file at path,
+// if path is not empty, and then by the environment, once it has checked
+// them with validate.
+func loadConfig(path string) (Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		err := c.readFile(path)
+		if err != nil {
+			return c, err
+		}
+	}
+	for _, s := range configSettings {
+		if v := os.Getenv(envName(s.name)); v != "" {
+			err := s.set(&c, v)
+			if err != nil {
+				return c, fmt.Errorf("invalid %s: %v", envName(s.name), err)
+			}
+		}
+	}
+	return c, c.validate()
+}
+
+// readFile sets c from the config file at path. It is a JSON object of
+// settings, like {"max_reminders": 100, "ack": true}, whose values may be
+// strings, numbers or booleans.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var settings map[string]interface{}
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	err = d.Decode(&settings)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	known := make(map[string]configSetting, len(configSettings))
+	for _, s := range configSettings {
+		known[s.name] = s
+	}
+	for name, v := range settings {
+		s, ok := known[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s: %s must be a string, number or boolean", path, name)
+		}
+		if value == "" {
+			continue
+		}
+		err := s.set(c, value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the settings of c are within bounds, naming the
+// environment variable of the first that is not.
+func (c *Config) validate() error {
+	invalid := func(name string, format string, v ...interface{}) error {
+		return fmt.Errorf("invalid %s: %s", envName(name), fmt.Sprintf(format, v...))
+	}
+	switch {
+	case c.LogFormat != "text" && c.LogFormat != "json":
+		return invalid("log_format", "%q is neither text nor json", c.LogFormat)
+	case c.MaxMessageLen < 1 || c.MaxMessageLen > maxMessageLen:
+		return invalid("max_message_len", "must be from 1 to %d", maxMessageLen)
+	case c.ReplyWindow <= 0:
+		return invalid("reply_window", "must be positive")
+	case c.RateInterval <= 0:
+		// It also paces pruneRateLimits, and time.Tick takes nothing else.
+		return invalid("rate_interval", "must be positive")
+	case c.RateBurst < 1:
+		return invalid("rate_burst", "must allow at least one command")
+	case c.KeepFiles < 1:
+		return invalid("keep_files", "must keep at least one file")
+	}
+	c.deliveryTemplate = defaultDeliveryTemplate
+	if c.DeliveryFormat != "" {
+		t, err := parseDeliveryTemplate(c.DeliveryFormat)
+		if err != nil {
+			return invalid("delivery_format", "%v", err)
+		}
+		c.deliveryTemplate = t
+	}
+	return nil
+}
+
+// apply makes c the settings in use.
+func (c *Config) apply() {
+	loggerDirname = c.LogDir
+	remindersDirname = c.RemindersDir
+	maxReminders = c.MaxReminders
+	maxReminderLen = c.MaxMessageLen
+	shutdownTimeout = c.ShutdownTimeout
+	dedupWindow = c.DedupWindow
+	replyWindow = c.ReplyWindow
+	rateInterval = c.RateInterval
+	rateBurst = c.RateBurst
+	deliveryTemplate = c.deliveryTemplate
+	dbPath = c.DB
+	keepFiles = c.KeepFiles
+	fileRetention = c.FileRetention
+	confirmReminders = c.Confirm
+	embedReminders = c.Embeds
+	ackReminders = c.Ack
+	ownerID = c.Owner
+	adminToken = c.AdminToken
+	tokenFile = c.TokenFile
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// setEnv sets the environment variables in env, keeping the others of
+// configSettings unset, until the returned function is called.
+func setEnv(t *testing.T, env map[string]string) (restore func()) {
+	t.Helper()
+	old := make(map[string]string)
+	for _, s := range configSettings {
+		name := envName(s.name)
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = v
+		}
+		os.Unsetenv(name)
+	}
+	for name, v := range env {
+		os.Setenv(name, v)
+	}
+	return func() {
+		for _, s := range configSettings {
+			os.Unsetenv(envName(s.name))
+		}
+		for name, v := range old {
+			os.Setenv(name, v)
+		}
+	}
+}
+
+// writeConfig writes a config file holding contents, returning its path.
+func writeConfig(t *testing.T, contents string) (path string, remove func()) {
+	t.Helper()
+	f, err := ioutil.TempFile("", "remindme-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(contents)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
+
+func TestConfigDefaults(t *testing.T) {
+	defer setEnv(t, nil)()
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.deliveryTemplate = defaultDeliveryTemplate
+	if c != want {
+		t.Errorf("loadConfig with nothing set = %+v, want %+v", c, want)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path, remove := writeConfig(t, `{
+		"max_reminders": 100,
+		"rate_burst": 3,
+		"ack": true,
+		"reply_window": "2d",
+		"log_dir": ""
+	}`)
+	defer remove()
+	defer setEnv(t, map[string]string{
+		"REMINDME_MAX_REMINDERS": "7",
+		"REMINDME_RATE_INTERVAL": "1m",
+		"REMINDME_OWNER":         "",
+	})()
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxReminders != 7 {
+		t.Errorf("max_reminders is %d, want 7 from the environment", c.MaxReminders)
+	}
+	if c.RateBurst != 3 || !c.Ack || c.ReplyWindow != 2*day {
+		t.Errorf("file settings gave %d, %t, %v, want 3, true, 2d", c.RateBurst, c.Ack, c.ReplyWindow)
+	}
+	if c.RateInterval != time.Minute {
+		t.Errorf("rate_interval is %v, want 1m from the environment", c.RateInterval)
+	}
+	if c.LogDir != "log/" {
+		t.Errorf("empty log_dir gave %q, want the default", c.LogDir)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		env  map[string]string
+	}{
+		{`{"rate_interval": "0s"}`, nil},
+		{`{"rate_burst": 0}`, nil},
+		{`{"no_such_setting": 1}`, nil},
+		{`{"max_reminders": [1]}`, nil},
+		{`{"max_reminders": "many"}`, nil},
+		{`not json`, nil},
+		{`{}`, map[string]string{"REMINDME_RATE_BURST": "-1"}},
+		{`{}`, map[string]string{"REMINDME_RATE_INTERVAL": "soon"}},
+		{`{}`, map[string]string{"REMINDME_LOG_FORMAT": "xml"}},
+		{`{}`, map[string]string{"REMINDME_MAX_MESSAGE_LEN": "2001"}},
+		{`{}`, map[string]string{"REMINDME_MAX_MESSAGE_LEN": "0"}},
+		{`{}`, map[string]string{"REMINDME_REPLY_WINDOW": "0s"}},
+		{`{}`, map[string]string{"REMINDME_KEEP_FILES": "0"}},
+		{`{}`, map[string]string{"REMINDME_CONFIRM": "maybe"}},
+		{`{}`, map[string]string{"REMINDME_DELIVERY_FORMAT": "{{.Bad"}},
+	} {
+		path, remove := writeConfig(t, test.file)
+		restore := setEnv(t, test.env)
+		_, err := loadConfig(path)
+		restore()
+		remove()
+		if err == nil {
+			t.Errorf("loadConfig accepted %s with %v", test.file, test.env)
+		}
+	}
+}
+
+func TestConfigKeepsSecretsOutOfEnv(t *testing.T) {
+	path, remove := writeConfig(t, `{"admin_token": "secret", "token_file": "/run/token"}`)
+	defer remove()
+	defer setEnv(t, nil)()
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AdminToken != "secret" || c.TokenFile != "/run/token" {
+		t.Errorf("got admin_token %q and token_file %q from the file", c.AdminToken, c.TokenFile)
+	}
+	for _, s := range configSettings {
+		if v, ok := os.LookupEnv(envName(s.name)); ok {
+			t.Errorf("loading the config file set %s=%q", envName(s.name), v)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "time"
+
+// A reminder set with after goes off some time after another reminder
+// owned by the same user, which it depends on, recording its id. While the
+// reminder it depends on is still to go off, the dependent follows it:
+// rescheduling it, by edit or snooze, moves the dependent by as much, and
+// cancelling it cancels the dependent too. Once it goes off the dependency
+// ends, and the dependent keeps its time. It also ends when either is
+// transferred to another user. Dependents may have dependents of their own,
+// which follow them likewise.
+
+// Expiration returns when the reminder with the given id owned by userID
+// goes off. It fails with errNotFound if there is no such reminder, or
+// with errFiring if it already went off and is awaiting delivery.
+func (rs *remindmeState) Expiration(userID string, id string) (time.Time, error) {
+	rs.Lock()
+	defer rs.Unlock()
+	k := rs.find(userID, id)
+	if k == -1 {
+		return time.Time{}, errNotFound
+	}
+	if rs.reminders[k].pending {
+		return time.Time{}, errFiring
+	}
+	return rs.reminders[k].expiration, nil
+}
+
+// dependents returns the ids of the reminders depending on the one with
+// the given id.
+// The lock must be held.
+func (rs *remindmeState) dependents(id string) []string {
+	var ids []string
+	for _, r := range rs.reminders {
+		if r.after == id {
+			ids = append(ids, r.id)
+		}
+	}
+	return ids
+}
+
+// shiftDependents moves the reminders depending on the one with the given
+// id by d, along with their own dependents. Those going off already are
+// left alone.
+// The lock must be held.
+func (rs *remindmeState) shiftDependents(id string, d time.Duration) {
+	if d == 0 {
+		return
+	}
+	for _, dep := range rs.dependents(id) {
+		k := rs.indexByID(dep)
+		if k == -1 || rs.reminders[k].pending || !rs.stop(k) {
+			continue
+		}
+		shifted := *rs.reminders[k]
+		shifted.expiration = shifted.expiration.Add(d)
+		rs.drop(k)
+		rs.insert(&shifted, rs.schedule(&shifted))
+		rs.appendJournal(append([]string{"add"}, shifted.record()...)...)
+		logger.User(shifted.userID).Infof("Moved reminder %s for %s along with reminder %s to go off %s",
+			dep, shifted.userID, id, shifted.expiration)
+		rs.shiftDependents(dep, d)
+	}
+}
+
+// dropDependents removes the reminders depending on the one with the given
+// id, along with their own dependents. Those going off already are left
+// to go off.
+// The lock must be held.
+func (rs *remindmeState) dropDependents(id string) {
+	for _, dep := range rs.dependents(id) {
+		k := rs.indexByID(dep)
+		if k == -1 || !rs.stop(k) {
+			continue
+		}
+		userID := rs.reminders[k].userID
+		rs.removeAt(k)
+		logger.User(userID).Infof("Removed reminder %s for %s along with reminder %s", dep, userID, id)
+		rs.dropDependents(dep)
+	}
+}
+
+// endDependencies ends the dependency of the reminders depending on the one
+// with the given id, which went off.
+// The lock must be held.
+func (rs *remindmeState) endDependencies(id string) {
+	for _, dep := range rs.dependents(id) {
+		k := rs.indexByID(dep)
+		if k == -1 || rs.reminders[k].pending || !rs.stop(k) {
+			continue
+		}
+		ended := *rs.reminders[k]
+		ended.after = ""
+		rs.drop(k)
+		rs.insert(&ended, rs.schedule(&ended))
+		rs.appendJournal(append([]string{"add"}, ended.record()...)...)
+	}
+}
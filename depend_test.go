@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// dependent returns a reminder for userID with the given id, going off
+// the given offset after fakeEpoch and depending on the one with id after.
+func dependent(userID, id string, expiration time.Duration, after string) *reminder {
+	r := testReminder(userID, id, 0, expiration)
+	r.after = after
+	return r
+}
+
+// addAllOrFail adds each of reminders to rs, failing t if one cannot be.
+func addAllOrFail(t *testing.T, rs *remindmeState, reminders ...*reminder) {
+	t.Helper()
+	for _, r := range reminders {
+		err := rs.Add(r, defaultMaxReminders)
+		if err != nil {
+			t.Fatalf("adding %s: %v", r.id, err)
+		}
+	}
+}
+
+// afterOf returns the dependency of the reminder with the given id, and
+// whether it is still stored.
+func afterOf(rs *remindmeState, id string) (string, bool) {
+	k := rs.indexByID(id)
+	if k == -1 {
+		return "", false
+	}
+	return rs.reminders[k].after, true
+}
+
+func TestEditMovesDependents(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		dependent("u", "bbbbbb", 2*time.Hour, "aaaaaa"),
+		dependent("u", "cccccc", 3*time.Hour, "bbbbbb"))
+	if !rs.Edit("u", "aaaaaa", "", fakeEpoch.Add(90*time.Minute)) {
+		t.Fatal("edit failed")
+	}
+	for id, want := range map[string]time.Duration{
+		"aaaaaa": 90 * time.Minute,
+		"bbbbbb": 150 * time.Minute,
+		"cccccc": 210 * time.Minute,
+	} {
+		k := rs.indexByID(id)
+		if k == -1 {
+			t.Fatalf("reminder %s gone", id)
+		}
+		if got := rs.reminders[k].expiration.Sub(fakeEpoch); got != want {
+			t.Errorf("reminder %s goes off after %v, want %v", id, got, want)
+		}
+	}
+	checkOrder(t, rs, []string{"aaaaaa", "bbbbbb", "cccccc"})
+}
+
+func TestRemoveDropsDependents(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		dependent("u", "bbbbbb", 2*time.Hour, "aaaaaa"),
+		dependent("u", "cccccc", 3*time.Hour, "bbbbbb"),
+		testReminder("u", "dddddd", 0, 4*time.Hour))
+	err := rs.Remove("u", "aaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, rs, []string{"dddddd"})
+}
+
+func TestRemoveAllDropsDependents(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	// v set a reminder for u and one of their own after it, which
+	// RemoveAll for u does not remove itself.
+	forU := testReminder("u", "aaaaaa", 0, time.Hour)
+	forU.authorID = "v"
+	addAllOrFail(t, rs,
+		forU,
+		dependent("v", "bbbbbb", 2*time.Hour, "aaaaaa"),
+		testReminder("v", "cccccc", 0, time.Hour))
+	removed, firing := rs.RemoveAll("u")
+	if removed != 1 || firing != 0 {
+		t.Errorf("RemoveAll = %d, %d, want 1, 0", removed, firing)
+	}
+	checkOrder(t, rs, []string{"cccccc"})
+}
+
+func TestTransferEndsDependencies(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		dependent("u", "bbbbbb", 2*time.Hour, "aaaaaa"),
+		dependent("u", "cccccc", 3*time.Hour, "bbbbbb"))
+	err := rs.Transfer("u", "bbbbbb", "v", defaultMaxReminders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"bbbbbb", "cccccc"} {
+		after, ok := afterOf(rs, id)
+		if !ok {
+			t.Fatalf("reminder %s gone", id)
+		}
+		if after != "" {
+			t.Errorf("reminder %s still depends on %s after the transfer", id, after)
+		}
+	}
+	if after, _ := afterOf(rs, "aaaaaa"); after != "" {
+		t.Errorf("reminder aaaaaa depends on %s", after)
+	}
+	k := rs.indexByID("bbbbbb")
+	if r := rs.reminders[k]; r.userID != "v" || !r.expiration.Equal(fakeEpoch.Add(2*time.Hour)) {
+		t.Errorf("transferred reminder is for %s at %v, want v at its old time", r.userID, r.expiration)
+	}
+	// Moving the reminder it depended on no longer moves it.
+	if !rs.Edit("u", "aaaaaa", "", fakeEpoch.Add(90*time.Minute)) {
+		t.Fatal("edit failed")
+	}
+	if r := rs.reminders[rs.indexByID("bbbbbb")]; !r.expiration.Equal(fakeEpoch.Add(2 * time.Hour)) {
+		t.Errorf("transferred reminder moved to %v along with its old dependency", r.expiration)
+	}
+	checkOrder(t, rs, []string{"aaaaaa", "cccccc", "bbbbbb"})
+}
+
+func TestCompleteEndsDependencies(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		dependent("u", "bbbbbb", 2*time.Hour, "aaaaaa"))
+	rs.Lock()
+	rs.stop(0)
+	rs.complete(0)
+	rs.Unlock()
+	after, ok := afterOf(rs, "bbbbbb")
+	if !ok || after != "" {
+		t.Errorf("dependent stored %t with dependency %q, want stored without one", ok, after)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's limits on the length of the parts of an embed, in characters.
+const (
+	embedDescriptionLimit = 4096
+	embedFooterLimit      = 2048
+)
+
+// embedColor is the color of the sidebar of reminder embeds.
+const embedColor = 0x5865f2
+
+// embedReminders makes reminders be delivered as embeds rather than plain
+// text, set with REMINDME_EMBEDS.
+var embedReminders bool
+
+// reminderEmbed returns an embed showing content as the description of r,
+// with when r was set, in loc, as the footer. The first of r's attachments
+// that is an image is shown as the embed's image; the others are listed
+// after content.
+func reminderEmbed(r *reminder, content string, loc *time.Location) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Color: embedColor,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: truncate("Set "+r.creation.In(loc).Format(displayTimeFmt), embedFooterLimit),
+		},
+	}
+	for _, u := range r.attachments {
+		if embed.Image == nil && isImage(u) {
+			embed.Image = &discordgo.MessageEmbedImage{URL: u}
+			continue
+		}
+		content += "\n" + u
+	}
+	embed.Description = truncate(content, embedDescriptionLimit)
+	return embed
+}
+
+// sendReminder sends content, the delivery of r, to channelID after
+// mention, which may be empty. With embedReminders it is sent as an embed
+// in one message, with mention as the text; otherwise as text in as many
+// messages as it takes, followed by r's attachments, which Discord shows
+// previews of. It returns the last message sent.
+func (rs *remindmeState) sendReminder(channelID string, mention string, r *reminder, content string, mentions *discordgo.MessageAllowedMentions) (*discordgo.Message, error) {
+	if !embedReminders {
+		if mention != "" {
+			content = mention + " " + content
+		}
+		for _, u := range r.attachments {
+			content += "\n" + u
+		}
+		return sendChunks(rs.Session(), channelID, content, mentions)
+	}
+	return rs.Session().ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         mention,
+		Embeds:          []*discordgo.MessageEmbed{reminderEmbed(r, content, rs.Zone(r.userID))},
+		AllowedMentions: mentions,
+	})
+}
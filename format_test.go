@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"0", 0},
+		{"90s", 90 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"1.5h", 90 * time.Minute},
+		{"1d", day},
+		{"2d12h", 60 * time.Hour},
+		{"1w", week},
+		{"2w3d", 17 * day},
+		{"1w1d1h1m1s", week + day + time.Hour + time.Minute + time.Second},
+		{"0.5w", 84 * time.Hour},
+		{"1y", time.Duration(year)},
+		{"+1d", day},
+		{"-1d", -day},
+		{"1h1h", 2 * time.Hour},
+	}
+	for _, test := range tests {
+		got, err := parseDuration(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestParseDurationMalformed(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"+",
+		"1",
+		"d",
+		"1h1x",
+		"1x",
+		"1 d",
+		"1h 30m",
+		".d",
+		"1..5h",
+		"1dd",
+		"99999999999999999999d",
+		"9223372036854775807w",
+		"106752d",
+		"1e3s",
+	} {
+		if d, err := parseDuration(in); err == nil {
+			t.Errorf("parseDuration(%q) = %v, want an error", in, d)
+		}
+	}
+}
+
+func TestParseReminderDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1w2d", 9 * day, false},
+		{"2y", maxDuration, false},
+		{"2y1s", 0, true},
+		{"0", 0, true},
+		{"-1h", 0, true},
+		{"1h1x", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseReminderDuration(test.in)
+		if (err != nil) != test.wantErr || !test.wantErr && got != test.want {
+			t.Errorf("parseReminderDuration(%q) = %v, %v; want %v, error %t",
+				test.in, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseDurationRandom(t *testing.T) {
+	// Durations in the units time.ParseDuration knows must parse the same,
+	// and no input at all may panic.
+	pieces := []string{"0", "1", "5", "9", "12", "99999999999", ".", "+", "-",
+		"ns", "us", "µs", "ms", "s", "m", "h", "d", "w", "y", "x", " ", "\xff"}
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 20000; n++ {
+		var b strings.Builder
+		for k := rnd.Intn(8); k >= 0; k-- {
+			b.WriteString(pieces[rnd.Intn(len(pieces))])
+		}
+		s := b.String()
+		mustNotPanic(t, s, func() {
+			got, err := parseDuration(s)
+			if strings.ContainsAny(s, "dwy") {
+				return
+			}
+			want, wantErr := time.ParseDuration(s)
+			if (err != nil) != (wantErr != nil) || err == nil && got != want {
+				t.Errorf("parseDuration(%q) = %v, %v; time.ParseDuration gives %v, %v",
+					s, got, err, want, wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"testing"
+)
+
+// The fuzz targets need Go 1.18. Their seeds run along with the other
+// tests; go test -fuzz=FuzzParseDuration, or FuzzReadFrom, runs the fuzzer.
+
+func FuzzParseDuration(f *testing.F) {
+	for _, s := range []string{
+		"", "0", "-0", "+0", "1h30m", "2d12h", "1.5h", "-1.5h", ".5s", "1.s",
+		"300ms", "1µs", "1μs", "1us", "1ns", "1w", "1y", "1h1h", "9223372036854775807ns",
+		"9223372036854775808ns", "2562047h47m16.854775807s", "106751d", "1x", "h", ".s",
+		"-", "1", "1.5", "\xff",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		d, err := parseDuration(s)
+		if err != nil || d == math.MinInt64 {
+			return
+		}
+		// What parses comes back from how time.Duration writes it.
+		again, err := parseDuration(d.String())
+		if err != nil || again != d {
+			t.Errorf("parseDuration(%q) = %v, but parseDuration(%q) = %v, %v",
+				s, d, d.String(), again, err)
+		}
+	})
+}
+
+func FuzzReadFrom(f *testing.F) {
+	rs := newTestState(newFakeClock())
+	rs.addAll(fullReminders())
+	bb := new(bytes.Buffer)
+	rs.WriteTo(bb)
+	f.Add(bb.Bytes())
+	f.Add(bb.Bytes()[:bb.Len()/2])
+	f.Add([]byte{})
+	f.Add([]byte("u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb\n"))
+	f.Add([]byte("snapshot,1\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb,id1,a,false\nend,1\n"))
+	f.Add([]byte("snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb,id1,a,false\nend,1\n"))
+	f.Add([]byte("snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a,id1,a,false\nend,2\n"))
+	f.Add([]byte("snapshot,9\nend,0\n"))
+	for _, record := range badRecords {
+		bb := new(bytes.Buffer)
+		ww := csv.NewWriter(bb)
+		ww.Write(record)
+		ww.Flush()
+		f.Add(bb.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, snapshot []byte) {
+		rs := newTestState(newFakeClock())
+		_, err := rs.ReadFrom(bytes.NewReader(snapshot))
+		if err != nil {
+			if len(rs.reminders) != 0 {
+				t.Fatalf("failed with %v but added %d reminders", err, len(rs.reminders))
+			}
+			return
+		}
+		for k, r := range rs.reminders {
+			if rs.byID[r.id] != r || rs.indexByID(r.id) != k {
+				t.Fatalf("reminder %s is not indexed", r.id)
+			}
+			if k > 0 && reminderLess(r, rs.reminders[k-1]) {
+				t.Fatalf("reminder %s is out of order", r.id)
+			}
+		}
+		// What was read writes back out as a snapshot that reads the same.
+		first := new(bytes.Buffer)
+		rs.WriteTo(first)
+		reread := newTestState(newFakeClock())
+		_, err = reread.ReadFrom(bytes.NewReader(first.Bytes()))
+		if err != nil {
+			t.Fatalf("unable to read back the snapshot written: %v\n%s", err, first)
+		}
+		second := new(bytes.Buffer)
+		reread.WriteTo(second)
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("snapshot changed on reading it back:\n%s\nthen\n%s", first, second)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Deliveries wait up to this long for a lost gateway connection to come
+// back before trying anyway.
+const reconnectTimeout = time.Minute
+
+// gatewayState tracks whether the gateway connection is up.
+type gatewayState struct {
+	mu sync.Mutex
+	// up is closed while the connection is up and replaced when it goes
+	// down.
+	up chan struct{}
+}
+
+var gateway = gatewayState{up: make(chan struct{})}
+
+func (g *gatewayState) set(connected bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.up:
+		if !connected {
+			g.up = make(chan struct{})
+		}
+	default:
+		if connected {
+			close(g.up)
+		}
+	}
+}
+
+// ready returns a channel that is closed once the connection is up.
+func (g *gatewayState) ready() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.up
+}
+
+// connected reports whether the connection is up.
+func (g *gatewayState) connected() bool {
+	select {
+	case <-g.ready():
+		return true
+	default:
+		return false
+	}
+}
+
+// registerGatewayHandlers keeps gateway up to date with s. They must be
+// registered before the session is opened. It returns a function removing
+// them again.
+func registerGatewayHandlers(s *discordgo.Session) (remove func()) {
+	removers := []func(){
+		s.AddHandler(func(s *discordgo.Session, _ *discordgo.Connect) {
+			gateway.set(true)
+		}),
+		s.AddHandler(func(s *discordgo.Session, _ *discordgo.Resumed) {
+			gateway.set(true)
+		}),
+		s.AddHandler(func(s *discordgo.Session, _ *discordgo.Disconnect) {
+			logger.Info("Gateway disconnected.")
+			gateway.set(false)
+		}),
+	}
+	return func() {
+		for _, remove := range removers {
+			remove()
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/qrpnxz/remindme
 go 1.12
 
 require (
-	github.com/bwmarrin/discordgo v0.19.0
+	github.com/bwmarrin/discordgo v0.24.0
 	github.com/docopt/docopt.go v0.0.0-20180111231733-ee0de3bc6815
+	github.com/mattn/go-sqlite3 v1.14.22
 )
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icsTimeFmt = "20060102T150405Z"
+	// icsLineLen is the most octets a line may have, excluding the line
+	// break, before it must be folded.
+	icsLineLen = 75
+)
+
+// snowflakeRe matches a Discord ID.
+var snowflakeRe = regexp.MustCompile(`^\d{15,20}$`)
+
+// icsEscaper escapes TEXT values as required by RFC 5545.
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// writeICSLine writes a content line, folding it into lines of at most
+// icsLineLen octets without splitting a UTF-8 sequence.
+func writeICSLine(bb *bytes.Buffer, line string) {
+	limit := icsLineLen
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		bb.WriteString(line[:n])
+		bb.WriteString("\r\n ")
+		line = line[n:]
+		// The leading space of a continuation line counts.
+		limit = icsLineLen - 1
+	}
+	bb.WriteString(line)
+	bb.WriteString("\r\n")
+}
+
+// formatICS returns reminders as an iCalendar with one event each.
+func formatICS(reminders []reminder, now time.Time) []byte {
+	bb := new(bytes.Buffer)
+	writeICSLine(bb, "BEGIN:VCALENDAR")
+	writeICSLine(bb, "VERSION:2.0")
+	writeICSLine(bb, "PRODID:-//qrpnxz//remindme//EN")
+	stamp := now.In(time.UTC).Format(icsTimeFmt)
+	for _, r := range reminders {
+		writeICSLine(bb, "BEGIN:VEVENT")
+		writeICSLine(bb, "UID:"+r.id+"-"+r.userID+"@remindme")
+		writeICSLine(bb, "DTSTAMP:"+stamp)
+		writeICSLine(bb, "CREATED:"+r.creation.In(time.UTC).Format(icsTimeFmt))
+		writeICSLine(bb, "DTSTART:"+r.expiration.In(time.UTC).Format(icsTimeFmt))
+		writeICSLine(bb, "SUMMARY:"+icsEscaper.Replace(formatMessage(&r)))
+		writeICSLine(bb, "END:VEVENT")
+	}
+	writeICSLine(bb, "END:VCALENDAR")
+	return bb.Bytes()
+}
+
+// remindersICSHandler serves the reminders of the user given by the user
+// query parameter as an iCalendar.
+func remindersICSHandler(w http.ResponseWriter, req *http.Request) {
+	if !authorized(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := req.URL.Query().Get("user")
+	if !snowflakeRe.MatchString(userID) {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+	var reminders []reminder
+	rmState.Lock()
+	for _, r := range rmState.byUser[userID] {
+		reminders = append(reminders, *r)
+	}
+	rmState.Unlock()
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, err := w.Write(formatICS(reminders, rmState.now()))
+	if err != nil {
+		logger.Error("writing reminders calendar: ", err)
+	}
+}
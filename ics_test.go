@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatICSStamp(t *testing.T) {
+	c := newFakeClock()
+	c.Advance(90 * time.Minute)
+	rs := newTestState(c)
+	reminders := []reminder{{
+		id:         "abcdef",
+		userID:     "u",
+		creation:   fakeEpoch,
+		expiration: fakeEpoch.Add(day),
+		message:    "msg",
+	}}
+	ics := string(formatICS(reminders, rs.now()))
+	for _, line := range []string{
+		"UID:abcdef-u@remindme\r\n",
+		"DTSTAMP:20200101T133000Z\r\n",
+		"CREATED:20200101T120000Z\r\n",
+		"DTSTART:20200102T120000Z\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("calendar is missing %q:\n%s", line, ics)
+		}
+	}
+}
+
+func TestFormatICSSummary(t *testing.T) {
+	// The calendar shows the whole message, as delivered, not the label.
+	reminders := []reminder{{
+		id:      "abcdef",
+		userID:  "u",
+		message: "pay the rent, today",
+		label:   "rent",
+		tags:    []string{"home"},
+	}}
+	ics := string(formatICS(reminders, fakeEpoch))
+	if want := "SUMMARY:pay the rent\\, today #home\r\n"; !strings.Contains(ics, want) {
+		t.Errorf("calendar is missing %q:\n%s", want, ics)
+	}
+}
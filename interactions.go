@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// remindmeCommand is the slash command counterpart of the !remindme text
+// commands.
+var remindmeCommand = &discordgo.ApplicationCommand{
+	Name:        "remindme",
+	Description: "Set and manage reminders",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set",
+			Description: "Set a reminder",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "duration",
+					Description: "How long from now, such as 1h30m, or when, such as tomorrow at 9am",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "message",
+					Description: "What to remind about, with any #tags",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "user",
+					Description: "Who to remind instead of yourself",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "here",
+					Description: "Post the reminder in this channel instead of privately",
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List your reminders",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "sent",
+					Description: "List the reminders you set for others instead",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "tag",
+					Description: "Only list reminders with this tag",
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "cancel",
+			Description: "Cancel a reminder",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "id",
+					Description: "ID of the reminder, as shown by list",
+					Required:    true,
+				},
+			},
+		},
+	},
+}
+
+func registerCommands(s *discordgo.Session) error {
+	_, err := s.ApplicationCommandCreate(s.State.User.ID, "", remindmeCommand)
+	return err
+}
+
+// respond replies to an interaction with a message only its user can see.
+func respond(s *discordgo.Session, i *discordgo.Interaction, content string) {
+	err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			AllowedMentions: noMentions,
+			Flags:           uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+	if err != nil {
+		logger.Errorf("responding to interaction %s: %v", i.ID, err)
+	}
+}
+
+func interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	data := i.ApplicationCommandData()
+	if data.Name != remindmeCommand.Name || len(data.Options) == 0 {
+		return
+	}
+	user := i.User
+	if i.Member != nil {
+		user = i.Member.User
+	}
+	sub := data.Options[0]
+	opts := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, o := range sub.Options {
+		opts[o.Name] = o
+	}
+	if !allowCommand(user.ID, rmState.now()) {
+		logger.User(user.ID).Infof("Rate limited slash command from %s", (*userLog)(user))
+		respond(s, i.Interaction, "you are sending commands too quickly, try again in a few seconds")
+		return
+	}
+	logger.User(user.ID).Infof("User %s sent slash command %s", (*userLog)(user), sub.Name)
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	switch sub.Name {
+	case "set":
+		target := user
+		if o, ok := opts["user"]; ok {
+			target = o.UserValue(nil)
+			if data.Resolved != nil && data.Resolved.Users[target.ID] != nil {
+				target = data.Resolved.Users[target.ID]
+			}
+		}
+		words := strings.Fields(opts["duration"].StringValue())
+		expiration, n, err := parseWhen(words, rmState.clock.Now().In(rmState.Zone(user.ID)))
+		if err == nil && n != len(words) {
+			err = fmt.Errorf("unexpected %q in duration", strings.Join(words[n:], " "))
+		}
+		if err != nil {
+			respond(s, i.Interaction, err.Error())
+			return
+		}
+		words, tags := parseTags(strings.Fields(opts["message"].StringValue()))
+		if blankMessage(words) {
+			respond(s, i.Interaction, "missing message")
+			return
+		}
+		var channelID string
+		if o, ok := opts["here"]; ok && o.BoolValue() {
+			channelID = i.ChannelID
+		}
+		r, err := setReminder(user, target, &reminder{
+			expiration: expiration,
+			message:    strings.Join(words, " "),
+			channelID:  channelID,
+			tags:       tags,
+		})
+		if err != nil {
+			respond(s, i.Interaction, err.Error())
+			return
+		}
+		respond(s, i.Interaction, fmt.Sprintf("set reminder `%s` to go off %s",
+			r.id, r.expiration.In(rmState.Zone(user.ID)).Format(time.RFC3339)))
+	case "list":
+		sent := false
+		if o, ok := opts["sent"]; ok {
+			sent = o.BoolValue()
+		}
+		var tag string
+		if o, ok := opts["tag"]; ok {
+			tag = strings.TrimPrefix(o.StringValue(), "#")
+		}
+		loc := rmState.Zone(user.ID)
+		pages := listReminders(user.ID, tag, loc)
+		if sent {
+			pages = listSentReminders(user.ID, tag, loc)
+		}
+		if len(pages) == 0 {
+			if sent {
+				respond(s, i.Interaction, "you have no reminders set for others")
+			} else {
+				respond(s, i.Interaction, "you have no reminders")
+			}
+			return
+		}
+		respond(s, i.Interaction, pages[0])
+		for _, page := range pages[1:] {
+			_, err := s.FollowupMessageCreate(s.State.User.ID, i.Interaction, false,
+				&discordgo.WebhookParams{
+					Content:         page,
+					AllowedMentions: noMentions,
+					Flags:           uint64(discordgo.MessageFlagsEphemeral),
+				})
+			if err != nil {
+				logger.Errorf("following up interaction %s: %v", i.ID, err)
+			}
+		}
+	case "cancel":
+		id := strings.ToLower(opts["id"].StringValue())
+		switch rmState.Remove(user.ID, id) {
+		case nil:
+			respond(s, i.Interaction, fmt.Sprintf("cancelled reminder `%s`", id))
+		case errFiring:
+			respond(s, i.Interaction, fmt.Sprintf("reminder `%s` is already going off", id))
+		default:
+			respond(s, i.Interaction, fmt.Sprintf("you have no reminder `%s`", id))
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The journal is an append-only log of every change to rmState, one CSV
+// record per event:
+//
+//	add,<userID>,<creation>,<expiration>,<message>,<id>,<authorID>,<pending>,<channelID>,<tags>,<daily>,<quote>,<warn>,<zone>,<attachments>,<priority>,<after>,<label>
+//	remove,<userID>,<id>
+//	zone,<userID>,<zone>
+//	prefix,<guildID>,<prefix>
+//	pause,<userID>,<since>
+//	resume,<userID>
+//	template,<userID>,<name>,<message>
+//	channel,<userID>,<channelID>
+//	trigger,<messageID>,<duration>,<reminder fields as in add>
+//	untrigger,<messageID>
+//
+// Replaying it rebuilds the state after a crash. It is periodically
+// compacted down to the events describing the live state.
+//
+// Each event is written to the journal as it happens, so that it survives
+// the process being killed. Syncing it to disk, so that it also survives
+// the machine going down, is left to the save coordinator, which syncs at
+// most once every saveWindow however many events came in meanwhile.
+const (
+	journalFilename = "reminders.log"
+	compactInterval = time.Hour
+	saveWindow      = 2 * time.Second
+)
+
+// A reminders CSV record has between minReminderFields and reminderFields
+// fields, older records lacking the fields added since.
+const (
+	minReminderFields = 4
+	reminderFields    = 17
+)
+
+// reminderColumns names the fields of a reminders CSV record, in order, as
+// in the header of a version 3 snapshot.
+var reminderColumns = [reminderFields]string{
+	"user_id",
+	"creation",
+	"expiration",
+	"message",
+	"id",
+	"author_id",
+	"pending",
+	"channel_id",
+	"tags",
+	"daily",
+	"quote",
+	"warn",
+	"zone",
+	"attachments",
+	"priority",
+	"after",
+	"label",
+}
+
+// record returns the fields of r in the order of the reminders CSV.
+func (r *reminder) record() []string {
+	return []string{
+		r.userID,
+		r.creation.Format(time.RFC3339Nano),
+		r.expiration.Format(time.RFC3339Nano),
+		r.message,
+		r.id,
+		r.authorID,
+		strconv.FormatBool(r.pending),
+		r.channelID,
+		strings.Join(r.tags, " "),
+		r.daily,
+		r.quote,
+		formatWarn(r.warn),
+		r.zone,
+		strings.Join(r.attachments, " "),
+		formatPriority(r.priority),
+		r.after,
+		r.label,
+	}
+}
+
+// formatWarn formats a reminder's warning for its record: empty for none.
+func formatWarn(warn time.Duration) string {
+	if warn == 0 {
+		return ""
+	}
+	return warn.String()
+}
+
+// parseReminder parses a reminder from the fields of a reminders CSV
+// record.
+func parseReminder(record []string) (*reminder, error) {
+	if len(record) < minReminderFields || len(record) > reminderFields {
+		return nil, fmt.Errorf("invalid reminder record: %s", record)
+	}
+	r := new(reminder)
+	var err error
+	r.userID = record[0]
+	r.creation, err = time.Parse(time.RFC3339Nano, record[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid reminder record: %s", record)
+	}
+	r.expiration, err = time.Parse(time.RFC3339Nano, record[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid reminder record: %s", record)
+	}
+	r.message = record[3]
+	// Records written before reminders had IDs have no fifth field;
+	// Add assigns them a fresh one.
+	if len(record) > 4 {
+		r.id = record[4]
+	}
+	// Likewise, reminders could only be set for oneself before authorID.
+	r.authorID = r.userID
+	if len(record) > 5 {
+		r.authorID = record[5]
+	}
+	if len(record) > 6 {
+		r.pending, err = strconv.ParseBool(record[6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reminder record: %s", record)
+		}
+	}
+	if len(record) > 7 {
+		r.channelID = record[7]
+	}
+	if len(record) > 8 {
+		r.tags = strings.Fields(record[8])
+	}
+	if len(record) > 9 {
+		r.daily = record[9]
+	}
+	if len(record) > 10 {
+		r.quote = record[10]
+	}
+	if len(record) > 11 && record[11] != "" {
+		r.warn, err = time.ParseDuration(record[11])
+		if err != nil || r.warn < 0 {
+			return nil, fmt.Errorf("invalid reminder record: %s", record)
+		}
+	}
+	if len(record) > 12 {
+		r.zone = record[12]
+	}
+	if len(record) > 13 {
+		r.attachments = strings.Fields(record[13])
+	}
+	if len(record) > 14 {
+		r.priority, err = parsePriority(record[14])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reminder record: %s", record)
+		}
+	}
+	if len(record) > 15 {
+		r.after = record[15]
+	}
+	if len(record) > 16 {
+		r.label = record[16]
+	}
+	return r, nil
+}
+
+// appendJournal appends an event to the journal, if it is open, or writes
+// it through to the database in use instead. The journal is synced by the
+// save coordinator, if it is running, or right away otherwise.
+// The lock must be held.
+func (rs *remindmeState) appendJournal(event ...string) {
+	if rs.db != nil {
+		err := rs.applyDB(event)
+		if err != nil {
+			logger.Errorf("unable to store event %s: %v", event, err)
+		}
+		return
+	}
+	if rs.journal == nil {
+		return
+	}
+	ww := csv.NewWriter(rs.journal)
+	ww.Write(event)
+	ww.Flush()
+	err := ww.Error()
+	if err == nil && rs.saves == nil {
+		err = rs.journal.Sync()
+	} else if err == nil {
+		rs.unsynced = true
+		select {
+		case rs.saves <- struct{}{}:
+		default:
+			// The coordinator has yet to take the last event.
+		}
+	}
+	if err != nil {
+		logger.Errorf("unable to journal event %s: %v", event, err)
+	}
+}
+
+// startSaver starts the save coordinator.
+func (rs *remindmeState) startSaver() {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.saves = make(chan struct{}, 1)
+	rs.flushes = make(chan chan struct{})
+	go rs.saveLoop(rs.saves, rs.flushes)
+}
+
+// saveLoop is the save coordinator. Once an event comes in on saves, it
+// waits out saveWindow, taking any further events with it, and then syncs
+// the journal. It syncs the journal one last time and returns when asked to
+// on flushes, closing the channel it was sent.
+func (rs *remindmeState) saveLoop(saves <-chan struct{}, flushes <-chan chan struct{}) {
+	due := make(chan struct{}, 1)
+	waiting := false
+	for {
+		select {
+		case <-saves:
+			if !waiting {
+				waiting = true
+				rs.clock.AfterFunc(saveWindow, func() {
+					due <- struct{}{}
+				})
+			}
+		case <-due:
+			waiting = false
+			rs.syncJournal()
+		case done := <-flushes:
+			rs.syncJournal()
+			close(done)
+			return
+		}
+	}
+}
+
+// flushSaves has the save coordinator, if it is running, sync the journal
+// and stop, and waits for it to. Events appended afterwards are synced
+// right away.
+func (rs *remindmeState) flushSaves() {
+	rs.Lock()
+	flushes := rs.flushes
+	rs.saves = nil
+	rs.flushes = nil
+	rs.Unlock()
+	if flushes == nil {
+		return
+	}
+	done := make(chan struct{})
+	flushes <- done
+	<-done
+}
+
+// syncJournal syncs the events appended to the journal since it was last
+// synced.
+func (rs *remindmeState) syncJournal() {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.journal == nil || !rs.unsynced {
+		return
+	}
+	rs.unsynced = false
+	err := rs.journal.Sync()
+	if err != nil {
+		logger.Error("unable to sync reminders journal: ", err)
+	}
+}
+
+// replay rebuilds the state from the journal in r. The journal must not
+// be open. A malformed final record is assumed to be an interrupted write
+// and is ignored.
+func (rs *remindmeState) replay(r io.Reader) error {
+	var live []*reminder
+	index := make(map[string]int)
+	zones := make(map[string]*time.Location)
+	prefixes := make(map[string]string)
+	paused := make(map[string]time.Time)
+	var templates [][]string
+	channels := make(map[string]string)
+	triggers := make(map[string]*trigger)
+	apply := func(event []string) error {
+		if len(event) == 0 {
+			return fmt.Errorf("empty journal record")
+		}
+		switch event[0] {
+		case "add":
+			r, err := parseReminder(event[1:])
+			if err != nil {
+				return err
+			}
+			if r.id == "" {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			if i, ok := index[r.id]; ok {
+				live[i] = r
+				return nil
+			}
+			index[r.id] = len(live)
+			live = append(live, r)
+		case "remove":
+			if len(event) != 3 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			if i, ok := index[event[2]]; ok && live[i].userID == event[1] {
+				live[i] = nil
+				delete(index, event[2])
+			}
+		case "zone":
+			if len(event) != 3 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			loc, err := time.LoadLocation(event[2])
+			if err != nil {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			if loc == time.UTC {
+				delete(zones, event[1])
+			} else {
+				zones[event[1]] = loc
+			}
+		case "prefix":
+			if len(event) != 3 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			if event[2] == defaultPrefix {
+				delete(prefixes, event[1])
+			} else {
+				prefixes[event[1]] = event[2]
+			}
+		case "pause":
+			if len(event) != 3 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			since, err := time.Parse(time.RFC3339Nano, event[2])
+			if err != nil {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			paused[event[1]] = since
+		case "resume":
+			if len(event) != 2 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			delete(paused, event[1])
+		case "template":
+			if len(event) != 4 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			templates = append(templates, event[1:])
+		case "channel":
+			if len(event) != 3 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			if event[2] == "" {
+				delete(channels, event[1])
+			} else {
+				channels[event[1]] = event[2]
+			}
+		case "trigger":
+			t, err := parseTrigger(event[1:])
+			if err != nil {
+				return err
+			}
+			triggers[t.messageID] = t
+		case "untrigger":
+			if len(event) != 2 {
+				return fmt.Errorf("invalid journal record: %s", event)
+			}
+			delete(triggers, event[1])
+		default:
+			return fmt.Errorf("invalid journal record: %s", event)
+		}
+		return nil
+	}
+	rr := csv.NewReader(r)
+	rr.FieldsPerRecord = -1
+	for {
+		event, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = apply(event)
+		}
+		if err != nil {
+			if _, next := rr.Read(); next == io.EOF {
+				logger.Info("Ignoring incomplete final journal record: ", err)
+				break
+			}
+			return err
+		}
+	}
+	rs.Lock()
+	rs.zones = zones
+	rs.prefixes = prefixes
+	rs.paused = paused
+	rs.channels = channels
+	rs.templates = nil
+	for _, t := range templates {
+		rs.setTemplate(t[0], t[1], t[2])
+	}
+	for _, t := range triggers {
+		rs.addTrigger(t)
+	}
+	var reminders []*reminder
+	for _, r := range live {
+		if r != nil {
+			reminders = append(reminders, r)
+		}
+	}
+	rs.addAll(reminders)
+	rs.Unlock()
+	return nil
+}
+
+// rewriteJournal replaces the journal with one describing only the
+// current state and opens it for appending.
+// The lock must be held.
+func (rs *remindmeState) rewriteJournal() error {
+	err := os.Mkdir(remindersDirname, 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	journalPath := filepath.Join(remindersDirname, journalFilename)
+	err = writeFileAtomic(journalPath, func(w io.Writer) error {
+		ww := csv.NewWriter(w)
+		for userID, loc := range rs.zones {
+			ww.Write([]string{"zone", userID, loc.String()})
+		}
+		for guildID, prefix := range rs.prefixes {
+			ww.Write([]string{"prefix", guildID, prefix})
+		}
+		for userID, since := range rs.paused {
+			ww.Write([]string{"pause", userID, since.Format(time.RFC3339Nano)})
+		}
+		for userID, templates := range rs.templates {
+			for name, message := range templates {
+				ww.Write([]string{"template", userID, name, message})
+			}
+		}
+		for userID, channelID := range rs.channels {
+			ww.Write([]string{"channel", userID, channelID})
+		}
+		for _, t := range rs.triggers {
+			ww.Write(append([]string{"trigger"}, t.record()...))
+		}
+		for _, r := range rs.reminders {
+			ww.Write(append([]string{"add"}, r.record()...))
+		}
+		ww.Flush()
+		return ww.Error()
+	})
+	if err != nil {
+		return err
+	}
+	if rs.journal != nil {
+		rs.journal.Close()
+	}
+	// The rewritten journal was synced as a whole.
+	rs.unsynced = false
+	rs.journal, err = os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+// openJournal starts journaling changes to the state.
+func (rs *remindmeState) openJournal() error {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.rewriteJournal()
+}
+
+// Compact rewrites the open journal, dropping events that no longer
+// contribute to the state.
+func (rs *remindmeState) Compact() error {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.journal == nil {
+		return nil
+	}
+	return rs.rewriteJournal()
+}
+
+// closeJournal stops journaling changes to the state.
+// The lock must be held.
+func (rs *remindmeState) closeJournal() {
+	if rs.journal == nil {
+		return
+	}
+	if rs.unsynced {
+		rs.unsynced = false
+		err := rs.journal.Sync()
+		if err != nil {
+			logger.Error("unable to sync reminders journal: ", err)
+		}
+	}
+	err := rs.journal.Close()
+	if err != nil {
+		logger.Error("closing reminders journal: ", err)
+	}
+	rs.journal = nil
+}
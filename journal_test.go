@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awkwardMessages are messages that CSV has to quote.
+var awkwardMessages = []string{
+	"pay rent, then call mum",
+	`say "hello"`,
+	"two\nlines",
+	"windows\r\nline",
+	`"quoted, with a comma"`,
+	`""`,
+	`back\slash \n not a newline`,
+	",",
+	"trailing newline\n",
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	for _, message := range awkwardMessages {
+		r := fullReminders()[0]
+		r.message = message
+		r.label = message
+		bb := new(bytes.Buffer)
+		ww := csv.NewWriter(bb)
+		ww.Write(r.record())
+		ww.Flush()
+		record, err := csv.NewReader(bb).Read()
+		if err != nil {
+			t.Fatalf("reading back the record of %q: %v", message, err)
+		}
+		got, err := parseReminder(record)
+		if err != nil {
+			t.Fatalf("parsing the record of %q: %v", message, err)
+		}
+		// CSV reads \r\n in quoted fields as \n.
+		want := *r
+		want.message = strings.Replace(message, "\r\n", "\n", -1)
+		want.label = want.message
+		if got.String() != want.String() {
+			t.Errorf("read back\n%s\nwant\n%s", got, &want)
+		}
+	}
+}
+
+func TestSnapshotAwkwardMessages(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	for n, message := range awkwardMessages {
+		r := testReminder("u", string(rune('a'+n))+"aaaaa", 0, 0)
+		r.message = message
+		rs.Add(r, 0)
+	}
+	bb := new(bytes.Buffer)
+	_, err := rs.WriteTo(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminders, err := readSnapshot(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != len(awkwardMessages) {
+		t.Fatalf("read %d reminders, want %d", len(reminders), len(awkwardMessages))
+	}
+	for n, r := range reminders {
+		want := strings.Replace(awkwardMessages[n], "\r\n", "\n", -1)
+		if r.message != want {
+			t.Errorf("read back %q, want %q", r.message, want)
+		}
+	}
+}
+
+func TestReplayAwkwardMessages(t *testing.T) {
+	bb := new(bytes.Buffer)
+	ww := csv.NewWriter(bb)
+	for n, message := range awkwardMessages {
+		r := testReminder("u", string(rune('a'+n))+"aaaaa", 0, 0)
+		r.message = message
+		ww.Write(append([]string{"add"}, r.record()...))
+		ww.Write([]string{"template", "u", "t" + string(rune('a'+n)), message})
+	}
+	ww.Flush()
+	rs := newTestState(newFakeClock())
+	err := rs.replay(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.reminders) != len(awkwardMessages) {
+		t.Fatalf("replayed %d reminders, want %d", len(rs.reminders), len(awkwardMessages))
+	}
+	for n, r := range rs.reminders {
+		want := strings.Replace(awkwardMessages[n], "\r\n", "\n", -1)
+		if r.message != want {
+			t.Errorf("replayed %q, want %q", r.message, want)
+		}
+		if got := rs.templates["u"]["t"+string(rune('a'+n))]; got != want {
+			t.Errorf("replayed template %q, want %q", got, want)
+		}
+	}
+}
+
+// mustNotPanic calls f, failing t with name if it panics.
+func mustNotPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if err := recover(); err != nil {
+			t.Errorf("%s: panic: %v", name, err)
+		}
+	}()
+	f()
+}
+
+// badRecords are malformed reminder records, each with the fields of a
+// reminders CSV record.
+var badRecords = [][]string{
+	nil,
+	{},
+	{"u"},
+	{"u", "2020-01-01T00:00:00Z"},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z"},
+	append(fullReminders()[0].record(), "extra"),
+	append(fullReminders()[0].record(), make([]string, 100)...),
+	{"u", "yesterday", "2020-01-02T00:00:00Z", "msg"},
+	{"u", "2020-01-01T00:00:00Z", "", "msg"},
+	{"u", "2020-01-01", "2020-01-02T00:00:00Z", "msg"},
+	{"u", "2020-01-01T00:00:00Z", "2020-13-02T00:00:00Z", "msg"},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "msg", "id", "u", "yes"},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "msg", "id", "u", ""},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "msg", "id", "u", "false",
+		"", "", "", "", "-1m"},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "msg", "id", "u", "false",
+		"", "", "", "", "soon"},
+	{"u", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "msg", "id", "u", "false",
+		"", "", "", "", "", "", "", "urgent"},
+}
+
+func TestParseReminderMalformed(t *testing.T) {
+	for _, record := range badRecords {
+		mustNotPanic(t, strings.Join(record, ","), func() {
+			if r, err := parseReminder(record); err == nil {
+				t.Errorf("parseReminder(%q) = %s, want an error", record, r)
+			}
+		})
+	}
+}
+
+func TestReplayMalformed(t *testing.T) {
+	good := "add," + strings.Join(fullReminders()[2].record(), ",") + "\n"
+	var journals []string
+	for _, record := range badRecords {
+		bb := new(bytes.Buffer)
+		ww := csv.NewWriter(bb)
+		ww.Write(append([]string{"add"}, record...))
+		ww.Flush()
+		journals = append(journals, bb.String())
+	}
+	journals = append(journals,
+		"add\n",
+		"bogus,u\n",
+		"remove,u\n",
+		"remove,u,id,extra\n",
+		"zone,u\n",
+		"zone,u,Mars/Olympus_Mons\n",
+		"prefix,g\n",
+		"pause,u,yesterday\n",
+		"pause,u\n",
+		"resume\n",
+		"template,u,name\n",
+		"channel,u\n",
+		"trigger,m\n",
+		"trigger,m,soon,"+strings.Join(fullReminders()[2].record(), ",")+"\n",
+		"trigger,m,-1h,"+strings.Join(fullReminders()[2].record(), ",")+"\n",
+		"trigger,m,1h,u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg\n",
+		"untrigger\n",
+		"add,u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg\n",
+	)
+	for _, journal := range journals {
+		// A malformed record is only ignored at the end of the journal.
+		journal := journal + good
+		mustNotPanic(t, journal, func() {
+			c := newFakeClock()
+			rs := newTestState(c)
+			if err := rs.replay(strings.NewReader(journal)); err == nil {
+				t.Errorf("replay(%q) succeeded, want an error", journal)
+			}
+			if len(rs.reminders) != 0 || len(rs.triggers) != 0 || c.active() != 0 {
+				t.Errorf("replay(%q) failed leaving %d reminders, %d triggers and %d timers",
+					journal, len(rs.reminders), len(rs.triggers), c.active())
+			}
+		})
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	bb := new(bytes.Buffer)
+	ww := csv.NewWriter(bb)
+	for _, r := range fullReminders() {
+		ww.Write(append([]string{"add"}, r.record()...))
+	}
+	ww.Write([]string{"zone", "u", "Europe/Paris"})
+	ww.Write([]string{"template", "u", "name", "say \"hi\",\nthen go"})
+	ww.Write([]string{"remove", fullReminders()[2].userID, fullReminders()[2].id})
+	ww.Flush()
+	journal := bb.String()
+	// Wherever a write was cut off, the records before it are replayed and
+	// the partial one ignored.
+	for n := 0; n <= len(journal); n++ {
+		mustNotPanic(t, journal[:n], func() {
+			rs := newTestState(newFakeClock())
+			err := rs.replay(strings.NewReader(journal[:n]))
+			if err != nil {
+				t.Errorf("replay of the first %d bytes: %v", n, err)
+			}
+		})
+	}
+}
+
+// windowTimers returns the number of timers c started to go off saveWindow
+// after fakeEpoch, which only the save coordinator does.
+func windowTimers(c *fakeClock, n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for _, t := range c.timers {
+		if t.when.Equal(fakeEpoch.Add(time.Duration(n) * saveWindow)) {
+			count++
+		}
+	}
+	return count
+}
+
+// waitFor fails t unless cond becomes true within a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for ", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSaveCoalesces(t *testing.T) {
+	c, restore := useTestState(t)
+	defer restore()
+	rs := &rmState
+	err := rs.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		rs.Lock()
+		rs.closeJournal()
+		rs.Unlock()
+	}()
+	rs.startSaver()
+	defer rs.flushSaves()
+	unsynced := func() bool {
+		rs.Lock()
+		defer rs.Unlock()
+		return rs.unsynced
+	}
+	burst := func(from int) {
+		for n := from; n < from+100; n++ {
+			err := rs.Add(testReminder("u", fmt.Sprintf("%06d", n), 0, day), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	burst(0)
+	waitFor(t, "the first save to be scheduled", func() bool { return windowTimers(c, 1) > 0 })
+	if !unsynced() {
+		t.Fatal("journal synced before the save window ended")
+	}
+	c.Advance(saveWindow)
+	waitFor(t, "the first save", func() bool { return !unsynced() })
+	if n := windowTimers(c, 1); n != 1 {
+		t.Errorf("first burst of adds scheduled %d saves, want 1", n)
+	}
+
+	burst(100)
+	waitFor(t, "the second save to be scheduled", func() bool { return windowTimers(c, 2) > 0 })
+	rs.flushSaves()
+	if unsynced() {
+		t.Fatal("journal not synced by flushing")
+	}
+	if n := windowTimers(c, 2); n != 1 {
+		t.Errorf("second burst of adds scheduled %d saves, want 1", n)
+	}
+
+	// With the coordinator stopped, events are synced as they come.
+	burst(200)
+	if unsynced() {
+		t.Fatal("journal not synced after the coordinator stopped")
+	}
+	rs.Lock()
+	rs.closeJournal()
+	rs.Unlock()
+	f, err := os.Open(filepath.Join(remindersDirname, journalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	replayed := newTestState(c)
+	err = replayed.replay(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.reminders) != 300 {
+		t.Errorf("replayed %d reminders, want 300", len(replayed.reminders))
+	}
+}
+
+// BenchmarkAddBurst adds reminders as fast as it can, syncing the journal
+// after each one or leaving it to the save coordinator.
+func BenchmarkAddBurst(b *testing.B) {
+	for _, coalesce := range []bool{false, true} {
+		name := "SyncEach"
+		if coalesce {
+			name = "Coalesced"
+		}
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "remindme")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			oldDirname := remindersDirname
+			remindersDirname = dir
+			defer func() { remindersDirname = oldDirname }()
+			rs := newTestState(realClock{})
+			err = rs.openJournal()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if coalesce {
+				rs.startSaver()
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				// Far enough out not to go off during the benchmark.
+				r := testReminder("u", fmt.Sprintf("%09d", n), 0, 100*365*day)
+				err := rs.Add(r, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			rs.flushSaves()
+			b.StopTimer()
+			rs.reset()
+			rs.Lock()
+			rs.closeJournal()
+			rs.Unlock()
+		})
+	}
+}
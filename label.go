@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Labels may be at most maxLabelLen characters, and reminders without one
+// are shown by as much of their message.
+const maxLabelLen = 50
+
+// joinLabel joins back the words of a --label value in double quotes,
+// which splitting the command into fields took apart, so that docopt sees
+// the whole label as one argument.
+func joinLabel(argv []string) []string {
+	for i, arg := range argv {
+		start := i
+		value := strings.TrimPrefix(arg, "--label=")
+		if arg == "--label" {
+			if i+1 == len(argv) {
+				break
+			}
+			start = i + 1
+			value = argv[start]
+		} else if value == arg {
+			continue
+		}
+		if !strings.HasPrefix(value, `"`) || len(value) > 1 && strings.HasSuffix(value, `"`) {
+			continue
+		}
+		for end := start + 1; end < len(argv); end++ {
+			if strings.HasSuffix(argv[end], `"`) {
+				joined := strings.Join(argv[start:end+1], " ")
+				return append(append(argv[:start:start], joined), argv[end+1:]...)
+			}
+		}
+		break
+	}
+	return argv
+}
+
+// parseLabel parses the value of --label, which may be in double quotes.
+func parseLabel(s string) (string, error) {
+	if len(s) > 1 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("--label must not be empty")
+	}
+	if n := utf8.RuneCountInString(s); n > maxLabelLen {
+		return "", fmt.Errorf("that label is %d characters long, but may be at most %d",
+			n, maxLabelLen)
+	}
+	return s, nil
+}
+
+// formatLabel is formatMessage for where r is listed rather than
+// delivered: it shows r's label, or the start of its message if it has
+// none, in place of the message.
+func formatLabel(r *reminder) string {
+	label := r.label
+	if label == "" {
+		label = truncate(r.message, maxLabelLen)
+	}
+	if len(r.tags) == 0 {
+		return label
+	}
+	return label + " #" + strings.Join(r.tags, " #")
+}
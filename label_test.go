@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJoinLabel(t *testing.T) {
+	tests := []struct {
+		argv string
+		want []string
+	}{
+		{`1h --label=rent pay`, []string{"1h", "--label=rent", "pay"}},
+		{`1h --label="pay rent" now`, []string{"1h", `--label="pay rent"`, "now"}},
+		{`1h --label "pay the rent" now`, []string{"1h", "--label", `"pay the rent"`, "now"}},
+		{`1h --label="rent" now`, []string{"1h", `--label="rent"`, "now"}},
+		{`1h --label="pay rent now`, []string{"1h", `--label="pay`, "rent", "now"}},
+		{`1h --label`, []string{"1h", "--label"}},
+	}
+	for _, test := range tests {
+		got := joinLabel(strings.Fields(test.argv))
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("joinLabel(%s) = %q, want %q", test.argv, got, test.want)
+		}
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"rent", "rent", false},
+		{`"pay rent"`, "pay rent", false},
+		{`" padded "`, "padded", false},
+		{`""`, "", true},
+		{"   ", "", true},
+		{strings.Repeat("é", maxLabelLen), strings.Repeat("é", maxLabelLen), false},
+		{strings.Repeat("é", maxLabelLen+1), "", true},
+	}
+	for _, test := range tests {
+		got, err := parseLabel(test.in)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseLabel(%q) = %q, %v; want %q, error %t",
+				test.in, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestFormatLabel(t *testing.T) {
+	long := strings.Repeat("a", maxLabelLen+10)
+	tests := []struct {
+		r    reminder
+		want string
+	}{
+		{reminder{message: "pay the rent", label: "rent"}, "rent"},
+		{reminder{message: "pay the rent"}, "pay the rent"},
+		{reminder{message: long}, truncate(long, maxLabelLen)},
+		{reminder{message: "pay", label: "rent", tags: []string{"home", "bills"}}, "rent #home #bills"},
+	}
+	for _, test := range tests {
+		if got := formatLabel(&test.r); got != test.want {
+			t.Errorf("formatLabel(%q, %q) = %q, want %q", test.r.message, test.r.label, got, test.want)
+		}
+	}
+}
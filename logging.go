@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels: events are logged at levelInfo, failures at levelError.
+const (
+	levelInfo  = "info"
+	levelError = "error"
+)
+
+// A levelLogger logs messages at the level given by the method used, about
+// the user given to User, if any.
+type levelLogger struct {
+	sink   logSink
+	userID string
+}
+
+// A logSink writes out the messages of a levelLogger.
+type logSink interface {
+	// Output writes out msg, logged at level about userID, which may be
+	// empty. As for log.Logger.Output, calldepth is the number of frames
+	// to skip to find where msg was logged.
+	Output(calldepth int, level, userID, msg string) error
+}
+
+// newTextLogger returns a levelLogger writing to l, which shows neither
+// levels nor users beyond what the messages say.
+func newTextLogger(l *log.Logger) *levelLogger {
+	return &levelLogger{sink: textSink{l}}
+}
+
+// newJSONLogger returns a levelLogger writing JSON objects to w, one per
+// line.
+func newJSONLogger(w io.Writer) *levelLogger {
+	return &levelLogger{sink: &jsonSink{w: w}}
+}
+
+// User returns a logger like l, logging messages about userID.
+func (l *levelLogger) User(userID string) *levelLogger {
+	return &levelLogger{sink: l.sink, userID: userID}
+}
+
+// Info logs an event, with its arguments handled as by fmt.Sprint.
+func (l *levelLogger) Info(v ...interface{}) {
+	l.output(levelInfo, fmt.Sprint(v...))
+}
+
+// Infof logs an event, with its arguments handled as by fmt.Sprintf.
+func (l *levelLogger) Infof(format string, v ...interface{}) {
+	l.output(levelInfo, fmt.Sprintf(format, v...))
+}
+
+// Error logs a failure, with its arguments handled as by fmt.Sprint.
+func (l *levelLogger) Error(v ...interface{}) {
+	l.output(levelError, fmt.Sprint(v...))
+}
+
+// Errorf logs a failure, with its arguments handled as by fmt.Sprintf.
+func (l *levelLogger) Errorf(format string, v ...interface{}) {
+	l.output(levelError, fmt.Sprintf(format, v...))
+}
+
+// Panic is Error followed by a call to panic.
+func (l *levelLogger) Panic(v ...interface{}) {
+	s := fmt.Sprint(v...)
+	l.output(levelError, s)
+	panic(s)
+}
+
+// output has the sink write out msg for the caller of the logging method.
+func (l *levelLogger) output(level, msg string) {
+	l.sink.Output(3, level, l.userID, msg)
+}
+
+// A textSink writes messages as they are to a log.Logger.
+type textSink struct {
+	*log.Logger
+}
+
+func (ts textSink) Output(calldepth int, level, userID, msg string) error {
+	return ts.Logger.Output(calldepth+1, msg)
+}
+
+// jsonLogEntry is one line of the JSON log.
+type jsonLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Source    string `json:"source,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+// A jsonSink writes each message as a jsonLogEntry on its own line.
+type jsonSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (js *jsonSink) Output(calldepth int, level, userID, msg string) error {
+	entry := jsonLogEntry{
+		Timestamp: time.Now().In(time.UTC).Format(time.RFC3339Nano),
+		Level:     level,
+		UserID:    userID,
+		Message:   strings.TrimSuffix(msg, "\n"),
+	}
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		entry.Source = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	_, err = js.w.Write(append(b, '\n'))
+	return err
+}
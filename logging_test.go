@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger(t *testing.T) {
+	bb := new(bytes.Buffer)
+	l := newJSONLogger(bb)
+	l.User("123456789012345678").Infof("Removed reminder %s for %s", "abcdef", "123456789012345678")
+	l.Error("unable to sync reminders journal: ", "disk full")
+	// The level comes from the method, not from how the message starts.
+	l.Info("ignoring incomplete final journal record")
+	l.User("u").Errorf("Sent nothing\n")
+
+	lines := strings.Split(strings.TrimSuffix(bb.String(), "\n"), "\n")
+	want := []jsonLogEntry{
+		{Level: "info", UserID: "123456789012345678", Message: "Removed reminder abcdef for 123456789012345678"},
+		{Level: "error", Message: "unable to sync reminders journal: disk full"},
+		{Level: "info", Message: "ignoring incomplete final journal record"},
+		{Level: "error", UserID: "u", Message: "Sent nothing"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), bb)
+	}
+	for n, line := range lines {
+		var fields map[string]string
+		err := json.Unmarshal([]byte(line), &fields)
+		if err != nil {
+			t.Fatalf("line %d is not a JSON object: %v", n+1, err)
+		}
+		if _, ok := fields["time"]; ok {
+			t.Errorf("line %d has time in place of timestamp: %s", n+1, line)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fields["timestamp"]); err != nil {
+			t.Errorf("line %d has a bad timestamp: %v", n+1, err)
+		}
+		if !strings.HasPrefix(fields["source"], "logging_test.go:") {
+			t.Errorf("line %d has source %q, want logging_test.go", n+1, fields["source"])
+		}
+		got := jsonLogEntry{
+			Level:   fields["level"],
+			UserID:  fields["user_id"],
+			Message: fields["message"],
+		}
+		if got != want[n] {
+			t.Errorf("line %d is %+v, want %+v", n+1, got, want[n])
+		}
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	bb := new(bytes.Buffer)
+	l := newTextLogger(log.New(bb, "", log.Lshortfile))
+	l.User("u").Infof("Removed reminder %s for %s", "abcdef", "u")
+	l.Error("unable to sync reminders journal: ", "disk full")
+	got := strings.Split(strings.TrimSuffix(bb.String(), "\n"), "\n")
+	want := []string{
+		"Removed reminder abcdef for u",
+		"unable to sync reminders journal: disk full",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("logged %q, want %q", got, want)
+	}
+	for n, line := range got {
+		if !strings.HasPrefix(line, "logging_test.go:") || !strings.HasSuffix(line, ": "+want[n]) {
+			t.Errorf("logged %q, want logging_test.go:<line>: %s", line, want[n])
+		}
+	}
+}
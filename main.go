@@ -2,57 +2,237 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/docopt/docopt.go"
 )
 
+// loggerDirname and remindersDirname are where logs and reminders are
+// stored, set with REMINDME_LOG_DIR and REMINDME_REMINDERS_DIR.
+var (
+	loggerDirname    = "log/"
+	remindersDirname = "reminders/"
+)
+
 const (
-	loggerDirname       = "log/"
-	remindersDirname    = "reminders/"
 	remindersFilePrefix = "reminders-"
 	remindersFileSuffix = ".csv"
+	timezonesFilename   = "timezones.csv"
+	prefixesFilename    = "prefixes.csv"
+	reminderIDLen       = 6
+	// Fired reminders stay snoozable for this long.
+	snoozeWindow = time.Hour
+	// snooze-soon snoozes the reminders going off within this long.
+	snoozeSoonWindow = time.Hour
+	maxFiredPerUser  = 10
+
+	defaultMaxReminders = 50
+	// Reminder messages may be at most this many characters by default,
+	// leaving room in a Discord message for what is delivered around them.
+	defaultMaxReminderLen = 1500
+	defaultDedupWindow    = 5 * time.Second
+	defaultPrefix         = "!remindme"
+	maxPrefixLen          = 32
+	// Deliveries abort their retries on shutdown, so this mostly covers a
+	// single slow request.
+	defaultShutdownTimeout = 10 * time.Second
+	defaultKeepFiles       = 10
+	defaultFileRetention   = 30 * day
+	// Destructive commands must be confirmed within this long.
+	confirmTimeout = time.Minute
+	// displayTimeFmt is how times are shown in replies.
+	displayTimeFmt = "2006-01-02 15:04 MST"
+	// Discord rejects messages longer than this many characters.
+	maxMessageLen = 2000
+	// Delivering a reminder is retried this many times in total, waiting
+	// deliveryBackoff after the first failure and twice as long after each
+	// subsequent one.
+	deliveryAttempts = 5
+	deliveryBackoff  = 2 * time.Second
+	// At most concurrentDeliveries deliveries are attempted at once, each
+	// starting at least deliveryInterval after the last, so that many
+	// reminders going off together stay within Discord's rate limits.
+	concurrentDeliveries = 4
+	deliveryInterval     = 100 * time.Millisecond
+	// At most this many deliveries wait for their turn, which is as many
+	// as deliveryInterval lets start in well over a minute. Reminders going
+	// off beyond that are left pending for RetryPending.
+	maxQueuedDeliveries = 1000
+	// Reminders that could not be delivered are retried this often until
+	// they are this old.
+	pendingRetryInterval = time.Hour
+	pendingTTL           = 7 * day
+	// next shows this many reminders unless told otherwise, and at most
+	// maxNext.
+	defaultNext = 3
+	maxNext     = 20
+	// cancel --at matches reminders going off within this long of the
+	// given time, which is usually only precise to the minute.
+	cancelTolerance = time.Minute
+	// Reminders may not be set further in the future than this.
+	maxDuration = 2 * time.Duration(year)
+)
+
+var logger *levelLogger
+var maxReminders = defaultMaxReminders
+var maxReminderLen = defaultMaxReminderLen
+
+// Old log files and snapshots are deleted on startup, except for the
+// keepFiles newest and those younger than fileRetention.
+var (
+	keepFiles     = defaultKeepFiles
+	fileRetention = defaultFileRetention
 )
 
-var logger *log.Logger
+// shutdownTimeout is how long to wait for deliveries to finish on shutdown.
+var shutdownTimeout = defaultShutdownTimeout
+
+// Reminders with the same user and message going off less than dedupWindow
+// apart are taken to be set twice by mistake. Zero disables the check.
+var dedupWindow = defaultDedupWindow
+
+// confirmReminders makes every new reminder be confirmed as if set with
+// --confirm.
+var confirmReminders bool
+
+// stop is closed, by requestStop, to shut down.
 var stop = make(chan struct{})
+var stopOnce sync.Once
+
+// requestStop asks for the bot to shut down. It never blocks, so any
+// number of goroutines may call it, any number of times.
+func requestStop() {
+	stopOnce.Do(func() {
+		close(stop)
+	})
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
 var internalErrMsg = &discordgo.MessageSend{
 	Content: "internal error",
 }
 
+// noMentions keeps user-supplied text in the bot's messages, such as
+// reminder messages, from pinging anyone.
+var noMentions = &discordgo.MessageAllowedMentions{
+	Parse: []discordgo.AllowedMentionType{},
+}
+
 func sendMsg(s *discordgo.Session, channelID string, msg string) {
-	_, err := s.ChannelMessageSend(channelID, msg)
-	if err != nil {
-		logger.Printf("sending message %v: %v\n", msg, err)
+	for _, chunk := range splitMessage(msg) {
+		sendMsgCmplx(s, channelID, &discordgo.MessageSend{
+			Content:         chunk,
+			AllowedMentions: noMentions,
+		})
+	}
+}
+
+// sendChunks sends content to channelID in as many messages as it takes,
+// stopping at the first error. It returns the last message sent.
+func sendChunks(s *discordgo.Session, channelID, content string, mentions *discordgo.MessageAllowedMentions) (*discordgo.Message, error) {
+	var msg *discordgo.Message
+	for _, chunk := range splitMessage(content) {
+		var err error
+		msg, err = s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:         chunk,
+			AllowedMentions: mentions,
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
+	return msg, nil
 }
 
 func sendMsgCmplx(s *discordgo.Session, channelID string, msg *discordgo.MessageSend) {
 	_, err := s.ChannelMessageSendComplex(channelID, msg)
 	if err != nil {
-		logger.Printf("sending message %v: %v\n", msg, err)
+		logger.Errorf("sending message %v: %v\n", msg, err)
 	}
 }
 
 func addReaction(s *discordgo.Session, channelID string, messageID string, emoji string) {
 	err := s.MessageReactionAdd(channelID, messageID, emoji)
 	if err != nil {
-		logger.Printf("adding reaction %v: %v\n", emoji, err)
+		logger.Errorf("adding reaction %v: %v\n", emoji, err)
+	}
+}
+
+// cancelFailed tells the author of m why cancelling, giving away or
+// following a reminder failed with err, as returned by Remove, Transfer or
+// Expiration: a reminder that is going off already is explained, and one
+// that does not exist is reacted to with ❌.
+func cancelFailed(s *discordgo.Session, m *discordgo.MessageCreate, err error) {
+	if err == errFiring {
+		sendMsg(s, m.ChannelID, "that reminder is already going off")
+		return
+	}
+	addReaction(s, m.ChannelID, m.ID, "❌")
+}
+
+// confirm asks userID in channelID to confirm prompt by reacting to it with
+// ✅ within confirmTimeout. action is run once they do; after the timeout,
+// the prompt is abandoned.
+func confirm(s *discordgo.Session, channelID string, userID string, prompt string, action func()) error {
+	msg, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         prompt,
+		AllowedMentions: noMentions,
+	})
+	if err != nil {
+		return err
 	}
+	var once sync.Once
+	var removeHandler func()
+	timer := time.AfterFunc(confirmTimeout, func() {
+		once.Do(func() {
+			removeHandler()
+			sendMsg(s, channelID, "confirmation timed out")
+		})
+	})
+	removeHandler = s.AddHandler(func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+		if r.MessageID != msg.ID || r.UserID != userID || r.Emoji.Name != "✅" {
+			return
+		}
+		once.Do(func() {
+			timer.Stop()
+			// Removing a handler from within it must not wait for the
+			// handler to return.
+			go removeHandler()
+			action()
+		})
+	})
+	addReaction(s, channelID, msg.ID, "✅")
+	return nil
 }
 
 type userLog discordgo.User
@@ -62,62 +242,445 @@ func (u *userLog) String() string {
 		(*discordgo.User)(u).String(), u.ID)
 }
 
+// A reminder is delivered to userID and was set by authorID. Both users own
+// the reminder: either may cancel it, and it is listed under userID.
 type reminder struct {
+	id         string
 	userID     string
+	authorID   string
 	creation   time.Time
 	expiration time.Time
 	message    string
+	// channelID is the channel to post the reminder in, or empty to send it
+	// to userID privately.
+	channelID string
+	// tags are the reminder's tags, without the leading #.
+	tags []string
+	// daily is the time of day, as 15:04 in the user's timezone, at which
+	// the reminder goes off every day, or empty if it goes off once.
+	daily string
+	// quote is the message to quote on delivery, as
+	// <guildID>/<channelID>/<messageID> like in its link, or empty.
+	quote string
+	// attachments are the URLs delivered along with the reminder.
+	attachments []string
+	// zone is the name of the timezone the reminder was set in, or empty
+	// for UTC.
+	zone string
+	// warn is how long before the reminder goes off its user is warned of
+	// it, or zero for no warning.
+	warn time.Duration
+	// priority orders the delivery of reminders going off together: one
+	// of lowPriority, normalPriority and highPriority.
+	priority int
+	// after is the id of the reminder this one depends on, or empty.
+	after string
+	// label is what the reminder is listed as in place of its message, or
+	// empty to list it by its message.
+	label string
+	// pending is set once delivery has failed. Pending reminders are
+	// retried every pendingRetryInterval until pendingTTL after expiration.
+	pending bool
 }
 
+// String describes r for logs. Snapshots and the journal are written with
+// record instead.
 func (r *reminder) String() string {
-	return fmt.Sprintf("%s,%s,%s,%q",
+	return fmt.Sprintf("%s,%s,%s,%q,%s,%s,%t,%s,%s,%s,%s,%s,%s,%s,%s,%s,%q",
 		r.userID,
 		r.creation.Format(time.RFC3339Nano),
 		r.expiration.Format(time.RFC3339Nano),
 		r.message,
+		r.id,
+		r.authorID,
+		r.pending,
+		r.channelID,
+		strings.Join(r.tags, " "),
+		r.daily,
+		r.quote,
+		formatWarn(r.warn),
+		r.zone,
+		strings.Join(r.attachments, " "),
+		formatPriority(r.priority),
+		r.after,
+		r.label,
 	)
 }
 
+// createdIn returns the timezone r was set in, or UTC if it is unknown.
+func (r *reminder) createdIn() *time.Location {
+	if r.zone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(r.zone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (r *reminder) ownedBy(userID string) bool {
+	return r.userID == userID || r.authorID == userID
+}
+
+func (r *reminder) hasTag(tag string) bool {
+	for _, t := range r.tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagRe matches a word that tags a reminder.
+var tagRe = regexp.MustCompile(`^#[\p{L}\p{N}_-]+$`)
+
+// parseTags takes the #tags out of words. A word meant to start with #
+// rather than be a tag is written with a backslash before the #.
+func parseTags(words []string) (rest []string, tags []string) {
+	for _, word := range words {
+		switch {
+		case tagRe.MatchString(word):
+			tags = append(tags, word[1:])
+		case strings.HasPrefix(word, `\#`):
+			rest = append(rest, word[1:])
+		default:
+			rest = append(rest, word)
+		}
+	}
+	return rest, tags
+}
+
+// blankMessage reports whether a reminder with the message words would
+// show nothing, as when it is only whitespace or invisible characters like
+// zero-width spaces.
+func blankMessage(words []string) bool {
+	for _, word := range words {
+		if strings.IndexFunc(word, func(r rune) bool {
+			return !unicode.IsSpace(r) && !unicode.Is(unicode.Cf, r)
+		}) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
 type remindmeState struct {
+	// reminders is sorted by reminderLess. timers[k] delivers reminders[k].
 	reminders []*reminder
-	timers    []*time.Timer
-	session   *discordgo.Session
+	timers    []Timer
+	// byUser holds each user's reminders, sorted as in reminders, and byID
+	// holds every reminder by its id, for looking them up without
+	// searching. Both are kept up to date by insert and drop, and by
+	// reindex after dropRange.
+	byUser map[string][]*reminder
+	byID   map[string]*reminder
+	// warnings holds the timers warning of the reminders set to warn their
+	// users beforehand, until they go off.
+	warnings map[*reminder]Timer
+	// fired holds each user's recently fired reminders, oldest first.
+	fired map[string][]*reminder
+	// acks holds the delivered reminders that may still be acknowledged,
+	// by the ID of the message they were delivered in.
+	acks map[string]pendingAck
+	// zones holds the timezone of each user who has set one.
+	zones map[string]*time.Location
+	// prefixes holds the command prefix of each guild that overrides
+	// defaultPrefix.
+	prefixes map[string]string
+	// paused holds when each user who paused their reminders did so.
+	paused map[string]time.Time
+	// templates holds the messages of each user's templates by name.
+	templates map[string]map[string]string
+	// channels holds the channel each user who chose one has their
+	// reminders posted in.
+	channels map[string]string
+	// triggers holds the reminders set with --after-reply that are awaiting
+	// a reply, by the ID of the message awaiting it.
+	triggers map[string]*trigger
+	// journal is the append-only log of changes, or nil if not journaling.
+	journal *os.File
+	// unsynced is set while the journal has events not yet synced.
+	unsynced bool
+	// saves tells the save coordinator of each event appended to the
+	// journal, and flushes has it sync the journal a last time and stop.
+	// Both are nil unless it is running.
+	saves   chan struct{}
+	flushes chan chan struct{}
+	// db is the database storing the state in place of the journal, or nil.
+	db *sql.DB
+	// done is closed when shutting down, with the lock held.
+	done chan struct{}
+	// deliveries counts the deliveries in flight, broadcasts included.
+	deliveries sync.WaitGroup
+	// clock starts the timers.
+	clock   Clock
+	session *discordgo.Session
 	*sync.Mutex
 }
 
-var rmState remindmeState
+var rmState = remindmeState{
+	done:  make(chan struct{}),
+	clock: realClock{},
+	Mutex: new(sync.Mutex),
+}
+
+// reloadLock is held for reading while handling a command and for writing
+// while reloading the state, so that no command sees a partial reload.
+var reloadLock sync.RWMutex
+
+var reminderIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").
+	WithPadding(base32.NoPadding)
 
-func (rs *remindmeState) Add(r *reminder) {
-	sendReminder := func() {
-		user, err := rs.session.User(r.userID)
+// newID returns a reminder ID not used by any current reminder.
+// The lock must be held.
+func (rs *remindmeState) newID() string {
+	buf := make([]byte, 4)
+	for {
+		_, err := rand.Read(buf)
 		if err != nil {
-			logger.Printf("unable to open private channel with %s to send the message \"%s\": %v",
-				r.userID, r.message, err)
-			return
+			logger.Panic("generating reminder id: ", err)
 		}
-		dm, err := rs.session.UserChannelCreate(user.ID)
-		if err != nil {
-			logger.Printf("unable to open private channel with %s to send the message \"%s\": %v",
-				(*userLog)(user), r.message, err)
-			return
+		id := reminderIDEncoding.EncodeToString(buf)[:reminderIDLen]
+		if rs.indexByID(id) == -1 {
+			return id
+		}
+	}
+}
+
+// indexByID returns the index of the reminder with the given id, or -1.
+// The lock must be held.
+func (rs *remindmeState) indexByID(id string) int {
+	r, ok := rs.byID[id]
+	if !ok {
+		return -1
+	}
+	return rs.indexOf(r)
+}
+
+// indexOf returns the index of r in rs.reminders, or -1.
+// The lock must be held.
+func (rs *remindmeState) indexOf(r *reminder) int {
+	k := sort.Search(len(rs.reminders), func(k int) bool {
+		return !reminderLess(rs.reminders[k], r)
+	})
+	if k == len(rs.reminders) || rs.reminders[k] != r {
+		return -1
+	}
+	return k
+}
+
+// reindex rebuilds byUser and byID for userID from reminders, after
+// dropRange has deleted some of theirs.
+// The lock must be held.
+func (rs *remindmeState) reindex(userID string) {
+	for _, r := range rs.byUser[userID] {
+		if rs.byID[r.id] == r {
+			delete(rs.byID, r.id)
 		}
-		sendMsg(rs.session, dm.ID, fmt.Sprintf("Reminder from %s: %s", r.creation, r.message))
-		logger.Printf("Sent reminder for %s created %s with the message \"%s\"",
-			(*userLog)(user), r.creation, r.message)
 	}
-	fromNow := time.Until(r.expiration)
-	if int64(fromNow) <= 1 {
-		sendReminder()
+	i := sort.Search(len(rs.reminders), func(i int) bool {
+		return rs.reminders[i].userID >= userID
+	})
+	j := sort.Search(len(rs.reminders), func(i int) bool {
+		return rs.reminders[i].userID > userID
+	})
+	if i == j {
+		delete(rs.byUser, userID)
 		return
 	}
+	rs.byUser[userID] = append([]*reminder(nil), rs.reminders[i:j]...)
+	for _, r := range rs.byUser[userID] {
+		rs.byID[r.id] = r
+	}
+}
+
+// userRange returns the bounds of userID's reminders in rs.reminders.
+// The lock must be held.
+func (rs *remindmeState) userRange(userID string) (i, j int) {
+	mine := rs.byUser[userID]
+	if len(mine) == 0 {
+		return 0, 0
+	}
+	i = rs.indexOf(mine[0])
+	return i, i + len(mine)
+}
+
+// find returns the index of the reminder with the given id owned by
+// userID, or -1.
+// The lock must be held.
+func (rs *remindmeState) find(userID string, id string) int {
+	k := rs.indexByID(id)
+	if k == -1 || !rs.reminders[k].ownedBy(userID) {
+		return -1
+	}
+	return k
+}
+
+// Zone returns userID's timezone, which is UTC unless they have set one.
+func (rs *remindmeState) Zone(userID string) *time.Location {
 	rs.Lock()
-	userID, expiration := r.userID, r.expiration
-	t := time.AfterFunc(fromNow, func() {
-		sendReminder()
-		rs.Remove(userID, expiration)
-	})
+	defer rs.Unlock()
+	if loc, ok := rs.zones[userID]; ok {
+		return loc
+	}
+	return time.UTC
+}
+
+func (rs *remindmeState) SetZone(userID string, loc *time.Location) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.zones == nil {
+		rs.zones = make(map[string]*time.Location)
+	}
+	if loc == time.UTC {
+		delete(rs.zones, userID)
+	} else {
+		rs.zones[userID] = loc
+	}
+	rs.appendJournal("zone", userID, loc.String())
+	logger.User(userID).Infof("Set timezone for %s to %s", userID, loc)
+}
+
+// Prefix returns the command prefix used in guildID.
+func (rs *remindmeState) Prefix(guildID string) string {
+	rs.Lock()
+	defer rs.Unlock()
+	if prefix, ok := rs.prefixes[guildID]; ok {
+		return prefix
+	}
+	return defaultPrefix
+}
+
+func (rs *remindmeState) SetPrefix(guildID string, prefix string) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.prefixes == nil {
+		rs.prefixes = make(map[string]string)
+	}
+	if prefix == defaultPrefix {
+		delete(rs.prefixes, guildID)
+	} else {
+		rs.prefixes[guildID] = prefix
+	}
+	rs.appendJournal("prefix", guildID, prefix)
+	logger.Infof("Set prefix for guild %s to %q", guildID, prefix)
+}
+
+// readPrefixes adds the prefixes of a prefixes CSV file, or none of them
+// if any record is invalid. The same goes for the other readers of CSV
+// files beside snapshots.
+func (rs *remindmeState) readPrefixes(r io.Reader) error {
+	rr := csv.NewReader(r)
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("invalid prefix record: %s", record)
+		}
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.prefixes == nil {
+		rs.prefixes = make(map[string]string)
+	}
+	for _, record := range records {
+		rs.prefixes[record[0]] = record[1]
+	}
+	return nil
+}
+
+func (rs *remindmeState) writePrefixes(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for guildID, prefix := range rs.prefixes {
+		ww.Write([]string{guildID, prefix})
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
+
+func (rs *remindmeState) readZones(r io.Reader) error {
+	rr := csv.NewReader(r)
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	zones := make(map[string]*time.Location, len(records))
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("invalid timezone record: %s", record)
+		}
+		loc, err := time.LoadLocation(record[1])
+		if err != nil {
+			return fmt.Errorf("invalid timezone record: %s", record)
+		}
+		zones[record[0]] = loc
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.zones == nil {
+		rs.zones = make(map[string]*time.Location)
+	}
+	for userID, loc := range zones {
+		rs.zones[userID] = loc
+	}
+	return nil
+}
+
+func (rs *remindmeState) writeZones(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for userID, loc := range rs.zones {
+		ww.Write([]string{userID, loc.String()})
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
+
+// recordFired remembers r as fired so that it may be snoozed, dropping
+// history older than snoozeWindow.
+// The lock must be held.
+func (rs *remindmeState) recordFired(r *reminder) {
+	if rs.fired == nil {
+		rs.fired = make(map[string][]*reminder)
+	}
+	history := rs.fired[r.userID]
+	i := 0
+	for i < len(history) && rs.since(history[i].expiration) > snoozeWindow {
+		i++
+	}
+	if len(history)-i >= maxFiredPerUser {
+		i = len(history) - maxFiredPerUser + 1
+	}
+	history = append(history[i:], r)
+	rs.fired[r.userID] = history
+}
+
+// reminderLess reports whether a is stored before b: by user, then by
+// expiration, creation and finally id, so that the order does not depend on
+// the order reminders were added in.
+func reminderLess(a, b *reminder) bool {
+	switch {
+	case a.userID != b.userID:
+		return a.userID < b.userID
+	case !a.expiration.Equal(b.expiration):
+		return a.expiration.Before(b.expiration)
+	case !a.creation.Equal(b.creation):
+		return a.creation.Before(b.creation)
+	}
+	return a.id < b.id
+}
+
+// insert stores r and its timer t in order.
+// The lock must be held.
+func (rs *remindmeState) insert(r *reminder, t Timer) {
 	i := sort.Search(len(rs.reminders), func(i int) bool {
-		return rs.reminders[i].userID > r.userID
+		return reminderLess(r, rs.reminders[i])
 	})
 	rs.reminders = append(rs.reminders, nil)
 	copy(rs.reminders[i+1:], rs.reminders[i:])
@@ -125,35 +688,60 @@ func (rs *remindmeState) Add(r *reminder) {
 	rs.timers = append(rs.timers, nil)
 	copy(rs.timers[i+1:], rs.timers[i:])
 	rs.timers[i] = t
-	rs.Unlock()
+	if rs.byUser == nil {
+		rs.byUser = make(map[string][]*reminder)
+		rs.byID = make(map[string]*reminder)
+	}
+	mine := rs.byUser[r.userID]
+	n := sort.Search(len(mine), func(n int) bool {
+		return reminderLess(r, mine[n])
+	})
+	mine = append(mine, nil)
+	copy(mine[n+1:], mine[n:])
+	mine[n] = r
+	rs.byUser[r.userID] = mine
+	rs.byID[r.id] = r
 }
 
-func (rs *remindmeState) Remove(userID string, expiration time.Time) bool {
-	rs.Lock()
-	defer rs.Unlock()
-	i := sort.Search(len(rmState.reminders), func(i int) bool {
-		return rmState.reminders[i].userID >= userID
-	})
-	j := sort.Search(len(rmState.reminders), func(i int) bool {
-		return rmState.reminders[i].userID > userID
-	})
-	if j - i == 0 {
-		logger.Print("Reminder for removal not found.")
-		return false
+// removeAt deletes the reminder and timer at index k.
+// The lock must be held.
+func (rs *remindmeState) removeAt(k int) {
+	rs.appendJournal("remove", rs.reminders[k].userID, rs.reminders[k].id)
+	rs.drop(k)
+}
+
+// dropRange deletes the reminders and timers from index i up to j without
+// journaling. Unlike drop, it leaves calling reindex to the caller.
+// The lock must be held.
+func (rs *remindmeState) dropRange(i, j int) {
+	copy(rs.reminders[i:], rs.reminders[j:])
+	copy(rs.timers[i:], rs.timers[j:])
+	end := len(rs.reminders) - (j - i)
+	for n := end; n < len(rs.reminders); n++ {
+		rs.reminders[n] = nil
+		rs.timers[n] = nil
 	}
-	authorReminders := rmState.reminders[i:j]
-	k := sort.Search(len(authorReminders), func(i int) bool {
-		return authorReminders[i].expiration.Before(expiration)
+	rs.reminders = rs.reminders[:end]
+	rs.timers = rs.timers[:end]
+}
+
+// drop is like removeAt without journaling.
+// The lock must be held.
+func (rs *remindmeState) drop(k int) {
+	r := rs.reminders[k]
+	mine := rs.byUser[r.userID]
+	n := sort.Search(len(mine), func(n int) bool {
+		return !reminderLess(mine[n], r)
 	})
-	k--
-	if k == -1 || !authorReminders[k].expiration.Equal(expiration) {
-		logger.Print("Reminder for removal not found.")
-		return false
+	copy(mine[n:], mine[n+1:])
+	mine[len(mine)-1] = nil
+	if mine = mine[:len(mine)-1]; len(mine) == 0 {
+		delete(rs.byUser, r.userID)
+	} else {
+		rs.byUser[r.userID] = mine
 	}
-	k += i
-	if !rs.timers[k].Stop() {
-		logger.Print("Reminder for removal already triggering.")
-		return false
+	if rs.byID[r.id] == r {
+		delete(rs.byID, r.id)
 	}
 	rs.reminders[k] = nil
 	copy(rs.reminders[k:], rs.reminders[k+1:])
@@ -161,154 +749,1878 @@ func (rs *remindmeState) Remove(userID string, expiration time.Time) bool {
 	rs.timers[k] = nil
 	copy(rs.timers[k:], rs.timers[k+1:])
 	rs.timers = rs.timers[:len(rs.timers)-1]
-	logger.Printf("Removed reminder for %s to go off %s", userID, expiration)
-	return true
 }
 
-func (rs *remindmeState) ReadFrom(r io.Reader) (int64, error) {
-	bb := new(bytes.Buffer)
-	n, err := bb.ReadFrom(r)
-	if err != nil {
-		return n, err
+var errShuttingDown = errors.New("shutting down")
+
+// errUserGone is returned by deliver when the user a reminder is for no
+// longer exists, or the bot can no longer see them, as when they left every
+// server it shares with them. Unlike other failures, retrying cannot help.
+var errUserGone = errors.New("user unknown to Discord")
+
+// isUnknownUser reports whether err is Discord failing a request because it
+// knows of no such user.
+func isUnknownUser(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	if !ok {
+		return false
 	}
-	rr := csv.NewReader(bb)
-	rr.ReuseRecord = true
-	for {
-		record, err := rr.Read()
-		if err != nil {
-			if err == io.EOF {
-				return n, nil
-			}
-			return n, err
-		}
-		r := new(reminder)
-		r.userID = record[0]
-		r.creation, err = time.Parse(time.RFC3339Nano, record[1])
-		if err != nil {
-			return n, fmt.Errorf("invalid reminder record: %s", record)
-		}
-		r.expiration, err = time.Parse(time.RFC3339Nano, record[2])
-		if err != nil {
-			return n, fmt.Errorf("invalid reminder record: %s", record)
-		}
-		r.message = record[3]
-		rs.Add(r)
+	if restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownUser {
+		return true
 	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
 }
 
-func (rs *remindmeState) WriteTo(w io.Writer) (int64, error) {
-	bb := new(bytes.Buffer)
-	for _, r := range rs.reminders {
-		bb.WriteString(r.String())
-		bb.WriteByte('\n')
-	}
-	return io.Copy(w, bb)
+// deliveryData is what a delivery template is executed with.
+type deliveryData struct {
+	Created time.Time
+	Elapsed time.Duration
+	Message string
+	// SetBy is the ID of the user who set the reminder for someone else,
+	// or empty.
+	SetBy string
 }
 
-func constructRMState(s *discordgo.Session) error {
-	rmState.session = s
-	rmState.Mutex = new(sync.Mutex)
-	remindersDir, err := os.Open(remindersDirname)
+// The delivery format may use {created}, {elapsed}, {message} and {setby}
+// as shorthands for the fields of deliveryData.
+var deliveryPlaceholders = strings.NewReplacer(
+	"{created}", "{{.Created}}",
+	"{elapsed}", "{{.Elapsed}}",
+	"{message}", "{{.Message}}",
+	"{setby}", "{{.SetBy}}",
+)
+
+var defaultDeliveryTemplate = template.Must(template.New("delivery").Parse(
+	"Reminder from {{.Created}}{{with .SetBy}} set by <@{{.}}>{{end}}: {{.Message}}"))
+
+// deliveryTemplate formats reminders on delivery.
+var deliveryTemplate = defaultDeliveryTemplate
+
+// parseDeliveryTemplate parses a delivery format and checks that it can be
+// executed.
+func parseDeliveryTemplate(format string) (*template.Template, error) {
+	t, err := template.New("delivery").Parse(deliveryPlaceholders.Replace(format))
 	if err != nil {
-		return fmt.Errorf("unable to open reminders directory: %v", err)
+		return nil, err
 	}
-	defer remindersDir.Close()
-	reminderFiles, err := remindersDir.Readdirnames(0)
+	_, err = formatDelivery(t, &reminder{
+		userID:   "0",
+		authorID: "1",
+		message:  "message",
+	}, time.UTC)
+	return t, err
+}
+
+// formatDelivery formats r with t, showing times in loc.
+func formatDelivery(t *template.Template, r *reminder, loc *time.Location) (string, error) {
+	data := deliveryData{
+		Created: r.creation.In(loc),
+		Elapsed: rmState.since(r.creation).Round(time.Second),
+		Message: r.message,
+	}
+	if r.authorID != r.userID {
+		data.SetBy = r.authorID
+	}
+	b := new(strings.Builder)
+	err := t.Execute(b, data)
+	return b.String(), err
+}
+
+// deliver sends r to its user, or posts it in its channel if it has one.
+func (rs *remindmeState) deliver(r *reminder) error {
+	content, err := formatDelivery(deliveryTemplate, r, rs.Zone(r.userID))
 	if err != nil {
-		return fmt.Errorf("unable to access reminders directory: %v", err)
+		logger.User(r.userID).Errorf("unable to format reminder %s, using the default format: %v", r.id, err)
+		content, _ = formatDelivery(defaultDeliveryTemplate, r, rs.Zone(r.userID))
 	}
-	if len(reminderFiles) == 0 {
-		return fmt.Errorf("no reminder files found")
+	if r.quote != "" {
+		content += rs.quoteContext(r)
+	}
+	// A reminder's own channel comes first, then its user's delivery
+	// channel. Only the latter falls back to sending it privately, as
+	// reminders set for a channel make no sense elsewhere.
+	if r.channelID != "" {
+		return rs.post(r.channelID, r, content)
+	}
+	if channelID := rs.DeliveryChannel(r.userID); channelID != "" {
+		err = rs.post(channelID, r, content)
+		if err == nil {
+			return nil
+		}
+		logger.User(r.userID).Errorf("unable to post reminder %s for %s in their delivery channel, sending it privately: %v",
+			r.id, r.userID, err)
+	}
+	s := rs.Session()
+	user, err := s.User(r.userID)
+	if isUnknownUser(err) {
+		return errUserGone
 	}
-	sort.Strings(reminderFiles)
-	remindersFile, err := os.Open(
-		filepath.Join(remindersDirname, reminderFiles[len(reminderFiles)-1]))
 	if err != nil {
-		return fmt.Errorf("unable to open reminders file: %v", err)
+		return fmt.Errorf("unable to get user %s: %v", r.userID, err)
 	}
-	_, err = rmState.ReadFrom(remindersFile)
+	dm, err := s.UserChannelCreate(user.ID)
 	if err != nil {
-		for i := range rmState.reminders {
-			rmState.reminders[i] = nil
-		}
-		rmState.reminders = rmState.reminders[:0]
-		for i := range rmState.timers {
-			rmState.timers[i].Stop()
-			rmState.timers[i] = nil
-		}
-		rmState.timers = rmState.timers[:0]
-		logger.Print("unable to import reminders file: ", err)
+		return fmt.Errorf("unable to open private channel with %s: %v", (*userLog)(user), err)
+	}
+	msg, err := rs.sendReminder(dm.ID, "", r, content, noMentions)
+	if err != nil {
+		return fmt.Errorf("unable to send to %s: %v", (*userLog)(user), err)
 	}
-	remindersFile.Close()
+	rs.awaitAck(msg, r)
 	return nil
 }
 
-func deconstructRMState() {
-	rmState.Lock()
-	for _, timer := range rmState.timers {
-		timer.Stop()
+// post posts r with the given content in channelID, mentioning its user.
+func (rs *remindmeState) post(channelID string, r *reminder, content string) error {
+	// Only ping the user being reminded.
+	msg, err := rs.sendReminder(channelID, "<@"+r.userID+">", r, content,
+		&discordgo.MessageAllowedMentions{Users: []string{r.userID}})
+	if err != nil {
+		return fmt.Errorf("unable to send to channel %s: %v", channelID, err)
 	}
-	rmState.Unlock()
-	err := os.Mkdir(remindersDirname, 0700)
-	if err != nil && !os.IsExist(err) {
-		logger.Print("unable to create reminders directory", err)
-		logger.Print("aborting records to stderr")
-		rmState.WriteTo(os.Stderr)
-		return
+	rs.awaitAck(msg, r)
+	return nil
+}
+
+// quoteContext returns the message r quotes as a block quote followed by a
+// link to it, or only the link if the message cannot be fetched, as when it
+// was deleted.
+func (rs *remindmeState) quoteContext(r *reminder) string {
+	link := "\nContext: https://discordapp.com/channels/" + r.quote
+	ids := strings.Split(r.quote, "/")
+	if len(ids) != 3 {
+		return link
 	}
-	remindersFile, _ := os.Create(
-		remindersDirname + remindersFilePrefix +
-			time.Now().In(time.UTC).Format(time.RFC3339) +
-			remindersFileSuffix)
-	rmState.WriteTo(remindersFile)
-	err = remindersFile.Close()
+	m, err := rs.Session().ChannelMessage(ids[1], ids[2])
 	if err != nil {
-		logger.Print("error exporting reminders: ", err)
+		logger.User(r.userID).Errorf("unable to fetch message quoted by reminder %s for %s: %v", r.id, r.userID, err)
+		return link
+	}
+	if m.Content == "" {
+		return link
 	}
+	quote := "\n> " + strings.Replace(m.Content, "\n", "\n> ", -1)
+	if m.Author != nil {
+		quote += "\n— " + m.Author.Username
+	}
+	return quote + link
 }
 
-func newRemindmeParser(s *discordgo.Session, channelID string) *docopt.Parser {
-	parser := new(docopt.Parser)
-	parser.HelpHandler = func(err error, usage string) {
-		var errStr string
+// deliverWithRetry delivers r, retrying with exponential backoff on
+// failure. Each attempt first waits up to reconnectTimeout for the gateway
+// connection to be up. It gives up early with errShuttingDown once rs.done
+// is closed.
+func (rs *remindmeState) deliverWithRetry(r *reminder) (attempts int, err error) {
+	backoff := deliveryBackoff
+	for attempts = 1; ; attempts++ {
+		select {
+		case <-gateway.ready():
+		case <-time.After(reconnectTimeout):
+		case <-rs.done:
+			return attempts - 1, errShuttingDown
+		}
+		err = deliveryThrottle.acquire(rs.done, r)
 		if err != nil {
-			errStr = err.Error()
+			return attempts - 1, err
 		}
-		buflen := len(errStr) + len(usage)
-		buf := make([]byte, buflen)
-		n := copy(buf, errStr)
-		copy(buf[n:], usage)
-		sendMsg(s, channelID, string(buf))
+		err = rs.deliver(r)
+		deliveryThrottle.release()
+		if err == nil || err == errUserGone || attempts == deliveryAttempts {
+			return attempts, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-rs.done:
+			return attempts, errShuttingDown
+		}
+		backoff *= 2
 	}
-	return parser
 }
 
-func remindmeHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	const remindmeUsage = `
-Usage:
-	!remindme list
-	!remindme cancel <expiration>
-	!remindme <duration> [-c|--withcontext] <message>...
-`
-	m.Content = strings.TrimLeftFunc(m.Content, unicode.IsSpace)
-	if m.Content == "" || !strings.HasPrefix(m.Content, "!remindme") {
-		return
-	}
-	argv := strings.Fields(m.Content)
-	parser := newRemindmeParser(s, m.ChannelID)
-	opts, err := parser.ParseArgs(remindmeUsage, argv[1:], "")
-	if err != nil {
-		if _, ok := err.(*docopt.UserError); !ok {
+// schedule starts the timer that delivers r and then removes it. If r's
+// user has paused their reminders, the timer is stopped right away, to be
+// started again by Resume.
+// The lock must be held.
+func (rs *remindmeState) schedule(r *reminder) Timer {
+	userID, id := r.userID, r.id
+	t := rs.clock.AfterFunc(rs.until(r.expiration), func() {
+		rs.Lock()
+		paused := rs.isPaused(userID)
+		rs.Unlock()
+		// The timer went off before it could be stopped.
+		if paused {
+			return
+		}
+		if !rs.startDelivery() {
+			return
+		}
+		defer rs.deliveries.Done()
+		attempts, err := rs.deliverWithRetry(r)
+		switch {
+		case err == errShuttingDown:
+			// Keep the reminder so that it is delivered after restarting.
+			logger.User(userID).Infof("Interrupted delivery of reminder %s for %s after %d attempts",
+				id, userID, attempts)
+			return
+		case err == errQueueFull:
+			logger.User(userID).Infof("Deferred delivery of reminder %s for %s, as too many deliveries are waiting",
+				id, userID)
+			rs.Lock()
+			rs.markPending(r)
+			rs.Unlock()
+			return
+		case err == errUserGone:
+			// Even a daily reminder has nobody left to go to.
+			logger.User(userID).Infof("Dropped reminder %s for %s, who is unknown to Discord", id, userID)
+			rs.Lock()
+			if k := rs.find(userID, id); k != -1 {
+				rs.removeAt(k)
+			}
+			rs.Unlock()
+			return
+		case err != nil && r.channelID == "" && rs.since(r.expiration) < pendingTTL:
+			logger.User(userID).Errorf("unable to deliver reminder %s for %s after %d attempts, retrying later: %v",
+				id, userID, attempts, err)
+			rs.Lock()
+			rs.markPending(r)
+			rs.Unlock()
+			return
+		case err != nil:
+			// Channel reminders are dropped rather than kept pending, as
+			// the bot has most likely lost access to the channel.
+			logger.User(userID).Errorf("unable to deliver reminder %s for %s with the message %q after %d attempts: %v",
+				id, userID, r.message, attempts, err)
+		default:
+			logger.User(userID).Infof("Sent reminder %s for %s created %s with the message %q after %d attempts",
+				id, userID, r.creation, r.message, attempts)
+		}
+		rs.Lock()
+		if k := rs.find(userID, id); k != -1 {
+			rs.complete(k)
+		}
+		rs.recordFired(r)
+		rs.Unlock()
+	})
+	if rs.isPaused(userID) {
+		t.Stop()
+	}
+	rs.scheduleWarning(r)
+	return t
+}
+
+// complete removes the reminder at k once it has gone off, or for a daily
+// reminder schedules its next occurrence instead. Either way, the
+// reminders depending on it no longer do.
+// The lock must be held.
+func (rs *remindmeState) complete(k int) {
+	r := rs.reminders[k]
+	rs.stopWarning(r)
+	if r.daily == "" {
+		rs.removeAt(k)
+		rs.endDependencies(r.id)
+		return
+	}
+	loc, ok := rs.zones[r.userID]
+	if !ok {
+		loc = time.UTC
+	}
+	expiration, ok := nextDaily(r.daily, rs.clock.Now().In(loc))
+	if !ok {
+		logger.User(r.userID).Errorf("unable to reschedule reminder %s for %s: invalid time of day %q",
+			r.id, r.userID, r.daily)
+		rs.removeAt(k)
+		rs.endDependencies(r.id)
+		return
+	}
+	next := *r
+	next.pending = false
+	next.expiration = expiration.In(time.UTC)
+	rs.drop(k)
+	rs.insert(&next, rs.schedule(&next))
+	rs.appendJournal(append([]string{"add"}, next.record()...)...)
+	logger.User(next.userID).Infof("Rescheduled daily reminder %s for %s to go off %s",
+		next.id, next.userID, next.expiration)
+	rs.endDependencies(r.id)
+}
+
+// markPending marks r as awaiting redelivery, if it is still present.
+// The lock must be held.
+func (rs *remindmeState) markPending(r *reminder) {
+	k := rs.indexByID(r.id)
+	if k == -1 || rs.reminders[k] != r {
+		return
+	}
+	pending := *r
+	pending.pending = true
+	rs.reminders[k] = &pending
+	rs.reindex(r.userID)
+	rs.appendJournal(append([]string{"add"}, pending.record()...)...)
+}
+
+// RetryPending tries once more to deliver each pending reminder, dropping
+// those older than pendingTTL.
+func (rs *remindmeState) RetryPending() {
+	var pending []*reminder
+	rs.Lock()
+	for _, r := range rs.reminders {
+		if r.pending && !rs.isPaused(r.userID) {
+			pending = append(pending, r)
+		}
+	}
+	rs.Unlock()
+	sort.SliceStable(pending, func(a, b int) bool {
+		return deliversBefore(pending[a], pending[b])
+	})
+	if !rs.startDelivery() {
+		return
+	}
+	defer rs.deliveries.Done()
+	for n, r := range pending {
+		if rs.since(r.expiration) < pendingTTL {
+			err := deliveryThrottle.acquire(rs.done, r)
+			if err == errQueueFull {
+				// Left pending for the next round.
+				logger.Infof("Deferred redelivery of %d reminders, as too many deliveries are waiting",
+					len(pending)-n)
+				return
+			}
+			if err != nil {
+				return
+			}
+			err = rs.deliver(r)
+			deliveryThrottle.release()
+			if err == errUserGone {
+				logger.User(r.userID).Infof("Dropped reminder %s for %s, who is unknown to Discord", r.id, r.userID)
+				rs.Lock()
+				if k := rs.indexByID(r.id); k != -1 && rs.reminders[k] == r {
+					rs.removeAt(k)
+				}
+				rs.Unlock()
+				continue
+			}
+			if err != nil {
+				logger.User(r.userID).Errorf("unable to redeliver reminder %s for %s: %v", r.id, r.userID, err)
+				continue
+			}
+			logger.User(r.userID).Infof("Redelivered reminder %s for %s created %s with the message %q",
+				r.id, r.userID, r.creation, r.message)
+		} else {
+			logger.User(r.userID).Infof("Dropped undeliverable reminder %s for %s with the message %q",
+				r.id, r.userID, r.message)
+		}
+		rs.Lock()
+		// Only remove the reminder if it was not edited meanwhile.
+		if k := rs.indexByID(r.id); k != -1 && rs.reminders[k] == r {
+			rs.complete(k)
+			rs.recordFired(r)
+		}
+		rs.Unlock()
+	}
+}
+
+// startDelivery counts a delivery as in flight, unless shutting down, in
+// which case it returns false and the reminder is left for after restarting.
+func (rs *remindmeState) startDelivery() bool {
+	rs.Lock()
+	defer rs.Unlock()
+	select {
+	case <-rs.done:
+		return false
+	default:
+	}
+	rs.deliveries.Add(1)
+	return true
+}
+
+// stop stops the timer and any warning of the reminder at k, reporting
+// whether the reminder may be changed: its timer had not fired yet, it is
+// pending or its user has paused their reminders.
+// The lock must be held.
+func (rs *remindmeState) stop(k int) bool {
+	rs.stopWarning(rs.reminders[k])
+	return rs.timers[k].Stop() || rs.reminders[k].pending || rs.isPaused(rs.reminders[k].userID)
+}
+
+var (
+	errTooManyReminders = errors.New("too many reminders")
+	errDuplicate        = errors.New("duplicate reminder")
+	errNotFound         = errors.New("no such reminder")
+	errFiring           = errors.New("reminder already going off")
+)
+
+// Add schedules r. If limit is positive, r is taken to be newly set: it is
+// rejected with errTooManyReminders if r's user already has limit
+// reminders, with errDuplicate if they have one with the same message
+// going off within dedupWindow of it, or with errNotFound if it depends on
+// a reminder of theirs that is gone.
+func (rs *remindmeState) Add(r *reminder, limit int) error {
+	rs.Lock()
+	if limit > 0 {
+		if r.after != "" && rs.find(r.userID, r.after) == -1 {
+			rs.Unlock()
+			return errNotFound
+		}
+		mine := rs.byUser[r.userID]
+		if len(mine) >= limit {
+			rs.Unlock()
+			return errTooManyReminders
+		}
+		for _, other := range mine {
+			d := other.expiration.Sub(r.expiration)
+			if other.message == r.message && d < dedupWindow && d > -dedupWindow {
+				rs.Unlock()
+				return errDuplicate
+			}
+		}
+	}
+	if r.id == "" {
+		r.id = rs.newID()
+	}
+	// Reminders that are already due are delivered right away by their
+	// timer.
+	rs.insert(r, rs.schedule(r))
+	rs.appendJournal(append([]string{"add"}, r.record()...)...)
+	rs.Unlock()
+	return nil
+}
+
+// addAll is like Add without a limit for a batch of reminders, such as
+// those loaded from a snapshot. All of them are inserted before any of
+// their timers is started, so that a reminder already due does not go off
+// while the others are still missing, as the reminders depending on it
+// would be.
+// The lock must be held.
+func (rs *remindmeState) addAll(reminders []*reminder) {
+	for _, r := range reminders {
+		// Ids must be unique for byID to find each reminder.
+		if _, taken := rs.byID[r.id]; r.id == "" || taken {
+			r.id = rs.newID()
+		}
+		rs.insert(r, nil)
+	}
+	for k, r := range rs.reminders {
+		if rs.timers[k] == nil {
+			rs.timers[k] = rs.schedule(r)
+		}
+	}
+	for _, r := range reminders {
+		rs.appendJournal(append([]string{"add"}, r.record()...)...)
+	}
+}
+
+// Edit changes the message and expiration of the reminder with the given id
+// owned by userID. An empty message or zero expiration is left unchanged. A
+// message longer than checkMessageLen allows is refused.
+func (rs *remindmeState) Edit(userID string, id string, message string, expiration time.Time) bool {
+	if checkMessageLen(message) != nil {
+		logger.Info("Edited message too long.")
+		return false
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	k := rs.find(userID, id)
+	if k == -1 {
+		logger.Info("Reminder for editing not found.")
+		return false
+	}
+	if !rs.stop(k) {
+		logger.Info("Reminder for editing already triggering.")
+		return false
+	}
+	// The old reminder may still be read by whoever copied it, so edit a
+	// copy.
+	edited := *rs.reminders[k]
+	edited.pending = false
+	if message != "" {
+		edited.message = message
+	}
+	if !expiration.IsZero() {
+		edited.expiration = expiration
+	}
+	moved := edited.expiration.Sub(rs.reminders[k].expiration)
+	rs.drop(k)
+	rs.insert(&edited, rs.schedule(&edited))
+	rs.appendJournal(append([]string{"add"}, edited.record()...)...)
+	rs.shiftDependents(id, moved)
+	logger.User(edited.userID).Infof("Edited reminder %s for %s to go off %s with the message %q",
+		id, edited.userID, edited.expiration, edited.message)
+	return true
+}
+
+// Transfer hands the reminder with the given id delivered to userID over to
+// be delivered to another user instead, keeping everything else about it
+// but its dependencies: as those are between reminders of the same user,
+// the transferred reminder no longer depends on another, and those that
+// depended on it no longer do, all keeping their times. It fails with
+// errNotFound if userID has no such reminder, errFiring if it
+// is going off already, or errTooManyReminders if the other user already
+// has limit reminders.
+func (rs *remindmeState) Transfer(userID string, id string, to string, limit int) error {
+	rs.Lock()
+	defer rs.Unlock()
+	k := rs.find(userID, id)
+	if k == -1 || rs.reminders[k].userID != userID {
+		logger.Info("Reminder for transfer not found.")
+		return errNotFound
+	}
+	if len(rs.byUser[to]) >= limit {
+		return errTooManyReminders
+	}
+	if !rs.stop(k) {
+		logger.Info("Reminder for transfer already triggering.")
+		return errFiring
+	}
+	transferred := *rs.reminders[k]
+	transferred.userID = to
+	transferred.after = ""
+	rs.drop(k)
+	rs.insert(&transferred, rs.schedule(&transferred))
+	// The record replaces the old one, as it has the same id.
+	rs.appendJournal(append([]string{"add"}, transferred.record()...)...)
+	rs.endDependencies(id)
+	logger.User(userID).Infof("Transferred reminder %s from %s to %s", id, userID, to)
+	return nil
+}
+
+// Remove removes userID's reminder with the given id. It fails with
+// errNotFound if there is no such reminder, or with errFiring if it is
+// going off already and so cannot be stopped.
+func (rs *remindmeState) Remove(userID string, id string) error {
+	rs.Lock()
+	defer rs.Unlock()
+	k := rs.find(userID, id)
+	if k == -1 {
+		if t := rs.findTrigger(userID, id); t != nil {
+			rs.dropTrigger(t)
+			logger.User(userID).Infof("Removed reminder %s awaiting a reply for %s", id, userID)
+			return nil
+		}
+		logger.Info("Reminder for removal not found.")
+		return errNotFound
+	}
+	if !rs.stop(k) {
+		logger.Info("Reminder for removal already triggering.")
+		return errFiring
+	}
+	rs.removeAt(k)
+	logger.User(userID).Infof("Removed reminder %s for %s", id, userID)
+	rs.dropDependents(id)
+	return nil
+}
+
+// RemoveAll removes every reminder delivered to userID. It returns the
+// number removed and the number skipped because they were already firing.
+func (rs *remindmeState) RemoveAll(userID string) (removed, firing int) {
+	rs.Lock()
+	defer rs.Unlock()
+	i, j := rs.userRange(userID)
+	k := i
+	var ids []string
+	for n := i; n < j; n++ {
+		if !rs.stop(n) {
+			// Its timer will remove it once delivered.
+			rs.reminders[k], rs.timers[k] = rs.reminders[n], rs.timers[n]
+			k++
+			continue
+		}
+		rs.appendJournal("remove", rs.reminders[n].userID, rs.reminders[n].id)
+		ids = append(ids, rs.reminders[n].id)
+	}
+	removed, firing = j-k, k-i
+	rs.dropRange(k, j)
+	rs.reindex(userID)
+	// As in Remove, their dependents go with them.
+	for _, id := range ids {
+		rs.dropDependents(id)
+	}
+	for _, t := range rs.triggers {
+		if t.r.userID == userID {
+			rs.dropTrigger(t)
+			removed++
+		}
+	}
+	logger.User(userID).Infof("Removed %d reminders for %s", removed, userID)
+	return removed, firing
+}
+
+// Matching returns copies of userID's reminders whose message contains
+// text, ignoring case.
+func (rs *remindmeState) Matching(userID string, text string) []reminder {
+	text = strings.ToLower(text)
+	var matches []reminder
+	rs.Lock()
+	defer rs.Unlock()
+	for _, r := range rs.byUser[userID] {
+		if strings.Contains(strings.ToLower(r.message), text) {
+			matches = append(matches, *r)
+		}
+	}
+	return matches
+}
+
+// Near returns copies of userID's reminders going off within tolerance of
+// t, nearest first.
+func (rs *remindmeState) Near(userID string, t time.Time, tolerance time.Duration) []reminder {
+	var near []reminder
+	rs.Lock()
+	for _, r := range rs.byUser[userID] {
+		if d := r.expiration.Sub(t); d <= tolerance && d >= -tolerance {
+			near = append(near, *r)
+		}
+	}
+	rs.Unlock()
+	sort.SliceStable(near, func(a, b int) bool {
+		da, db := near[a].expiration.Sub(t), near[b].expiration.Sub(t)
+		if da < 0 {
+			da = -da
+		}
+		if db < 0 {
+			db = -db
+		}
+		return da < db
+	})
+	return near
+}
+
+// Latest returns the ID of the reminder delivered to userID that was
+// created last, or the empty string if they have none.
+func (rs *remindmeState) Latest(userID string) string {
+	rs.Lock()
+	defer rs.Unlock()
+	var latest *reminder
+	for _, r := range rs.byUser[userID] {
+		if latest == nil || r.creation.After(latest.creation) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.id
+}
+
+// Shift moves every reminder delivered to userID by d. Reminders moved
+// into the past go off right away. It returns the number shifted and the
+// number skipped because they were already firing.
+func (rs *remindmeState) Shift(userID string, d time.Duration) (shifted, firing int) {
+	rs.Lock()
+	defer rs.Unlock()
+	i, j := rs.userRange(userID)
+	var moved []*reminder
+	k := i
+	for n := i; n < j; n++ {
+		// Pending reminders have gone off already.
+		if rs.reminders[n].pending || !rs.stop(n) {
+			rs.reminders[k], rs.timers[k] = rs.reminders[n], rs.timers[n]
+			k++
+			continue
+		}
+		r := *rs.reminders[n]
+		r.expiration = r.expiration.Add(d)
+		moved = append(moved, &r)
+	}
+	rs.dropRange(k, j)
+	rs.reindex(userID)
+	for _, r := range moved {
+		rs.insert(r, rs.schedule(r))
+		rs.appendJournal(append([]string{"add"}, r.record()...)...)
+	}
+	logger.User(userID).Infof("Shifted %d reminders for %s by %v", len(moved), userID, d)
+	return len(moved), k - i
+}
+
+// SnoozeSoon pushes back by d every reminder delivered to userID going off
+// within snoozeSoonWindow, or overdue while paused. Their dependents move
+// along with them, as for Snooze. It returns the number snoozed and the
+// number skipped because they were already firing, which still go off.
+func (rs *remindmeState) SnoozeSoon(userID string, d time.Duration) (snoozed, firing int) {
+	rs.Lock()
+	defer rs.Unlock()
+	soon := rs.now().Add(snoozeSoonWindow)
+	var ids []string
+	for _, r := range rs.byUser[userID] {
+		if r.expiration.After(soon) {
+			continue
+		}
+		// Pending reminders have gone off already.
+		if r.pending {
+			firing++
+			continue
+		}
+		ids = append(ids, r.id)
+	}
+	moved := make(map[string]bool)
+	for _, id := range ids {
+		k := rs.indexByID(id)
+		if k == -1 {
+			continue
+		}
+		// The reminder moved along with the one it depends on.
+		if after := rs.reminders[k].after; after != "" && moved[after] {
+			moved[id] = true
+			snoozed++
+			continue
+		}
+		if !rs.stop(k) {
+			firing++
+			continue
+		}
+		r := *rs.reminders[k]
+		r.expiration = r.expiration.Add(d)
+		rs.drop(k)
+		rs.insert(&r, rs.schedule(&r))
+		rs.appendJournal(append([]string{"add"}, r.record()...)...)
+		rs.shiftDependents(id, d)
+		moved[id] = true
+		snoozed++
+	}
+	logger.User(userID).Infof("Snoozed %d reminders for %s going off soon by %v", snoozed, userID, d)
+	return snoozed, firing
+}
+
+// Snooze reschedules userID's reminder with the given id to go off after d.
+// The reminder may be pending or one that fired within snoozeWindow.
+func (rs *remindmeState) Snooze(userID string, id string, d time.Duration) bool {
+	rs.Lock()
+	var snoozed reminder
+	// Whether a reminder still to go off was moved, rather than one that
+	// fired snoozed.
+	moved := false
+	if k := rs.find(userID, id); k != -1 {
+		if !rs.stop(k) {
+			rs.Unlock()
+			logger.Info("Reminder for snoozing already triggering.")
+			return false
+		}
+		snoozed = *rs.reminders[k]
+		snoozed.pending = false
+		rs.removeAt(k)
+		moved = true
+	} else {
+		history := rs.fired[userID]
+		k := len(history) - 1
+		for ; k >= 0; k-- {
+			if history[k].id == id && rs.since(history[k].expiration) <= snoozeWindow {
+				break
+			}
+		}
+		if k == -1 {
+			rs.Unlock()
+			logger.Info("Reminder for snoozing not found.")
+			return false
+		}
+		snoozed = *history[k]
+		// A daily reminder is still scheduled for its next day; the snooze
+		// is a one-off copy of it.
+		snoozed.daily = ""
+		snoozed.after = ""
+		if rs.indexByID(id) != -1 {
+			snoozed.id = ""
+		}
+	}
+	rs.Unlock()
+	previous := snoozed.expiration
+	snoozed.expiration = rs.now().Add(d)
+	rs.Add(&snoozed, 0)
+	if moved {
+		rs.Lock()
+		rs.shiftDependents(id, snoozed.expiration.Sub(previous))
+		rs.Unlock()
+	}
+	logger.User(userID).Infof("Snoozed reminder %s for %s to go off %s", id, userID, snoozed.expiration)
+	return true
+}
+
+// Source returns a copy of the reminder delivered to userID with the given
+// id, looking among those that fired within snoozeWindow too.
+func (rs *remindmeState) Source(userID string, id string) (reminder, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	for _, r := range rs.byUser[userID] {
+		if r.id == id {
+			return *r, true
+		}
+	}
+	history := rs.fired[userID]
+	for k := len(history) - 1; k >= 0; k-- {
+		if history[k].id == id && rs.since(history[k].expiration) <= snoozeWindow {
+			return *history[k], true
+		}
+	}
+	return reminder{}, false
+}
+
+// A snapshot is a CSV file of reminder records between a header and a
+// trailer. Since version 3, the header names the columns of the records,
+// as in reminderColumns:
+//
+//	snapshot,3,user_id,creation,expiration,message,...
+//	...
+//	end,<number of reminders>
+//
+// so that a snapshot can be read whatever columns it has: those missing,
+// as in a snapshot written before they were added, take their defaults,
+// and those unknown, as in one written by a later version, are ignored.
+// Before version 3, the header was only snapshot,<version>, and records
+// were as returned by record at the time, older ones shorter.
+//
+// A snapshot missing its trailer or with the wrong count is incomplete, as
+// when writing it was interrupted. Snapshots from before headers were
+// added have neither and are only checked to parse.
+//
+// Before version 2, and in snapshots without a header, messages were
+// escaped like Go strings rather than only quoted as CSV, so they are
+// unescaped when read.
+const (
+	snapshotHeader  = "snapshot"
+	snapshotVersion = "3"
+	snapshotTrailer = "end"
+)
+
+// snapshotColumns returns, for each of the columns of a version 3 snapshot
+// header, the index of the field of reminderColumns it holds, or -1 for a
+// column unknown to this version.
+func snapshotColumns(header []string) ([]int, error) {
+	index := make(map[string]int, len(reminderColumns))
+	for f, name := range reminderColumns {
+		index[name] = f
+	}
+	columns := make([]int, len(header))
+	seen := make(map[string]bool, len(header))
+	for c, name := range header {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate snapshot column %s", name)
+		}
+		seen[name] = true
+		f, ok := index[name]
+		if !ok {
+			f = -1
+		}
+		columns[c] = f
+	}
+	for _, name := range reminderColumns[:minReminderFields] {
+		if !seen[name] {
+			return nil, fmt.Errorf("snapshot is missing the %s column", name)
+		}
+	}
+	return columns, nil
+}
+
+// reorderRecord rearranges a record with the given columns, as returned by
+// snapshotColumns, into the fields of record, with defaults for those
+// missing.
+func reorderRecord(record []string, columns []int) ([]string, error) {
+	if len(record) != len(columns) {
+		return nil, fmt.Errorf("invalid reminder record: %s", record)
+	}
+	fields := make([]string, reminderFields)
+	fields[6] = "false"
+	authorSet := false
+	for c, f := range columns {
+		if f == -1 {
+			continue
+		}
+		fields[f] = record[c]
+		authorSet = authorSet || f == 5
+	}
+	// As in records from before authorID, the user set the reminder.
+	if !authorSet {
+		fields[5] = fields[0]
+	}
+	return fields, nil
+}
+
+// readSnapshot parses the reminders of a snapshot, failing if it is
+// incomplete.
+func readSnapshot(r io.Reader) ([]*reminder, error) {
+	rr := csv.NewReader(r)
+	rr.FieldsPerRecord = -1
+	records, err := rr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	escaped := true
+	var columns []int
+	if len(records) > 0 && records[0][0] == snapshotHeader {
+		header := records[0]
+		switch {
+		case len(header) == 2 && (header[1] == "1" || header[1] == "2"):
+			escaped = header[1] == "1"
+		case len(header) > 2 && header[1] == snapshotVersion:
+			escaped = false
+			columns, err = snapshotColumns(header[2:])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown snapshot header: %s", header)
+		}
+		last := records[len(records)-1]
+		if len(records) < 2 || last[0] != snapshotTrailer {
+			return nil, fmt.Errorf("incomplete snapshot: no trailer")
+		}
+		records = records[1 : len(records)-1]
+		if len(last) != 2 || last[1] != strconv.Itoa(len(records)) {
+			return nil, fmt.Errorf("incomplete snapshot: trailer %s does not match %d reminders",
+				last, len(records))
+		}
+	}
+	reminders := make([]*reminder, len(records))
+	for i, record := range records {
+		if columns != nil {
+			record, err = reorderRecord(record, columns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		reminders[i], err = parseReminder(record)
+		if err != nil {
+			return nil, err
+		}
+		if escaped {
+			if message, err := strconv.Unquote(`"` + reminders[i].message + `"`); err == nil {
+				reminders[i].message = message
+			}
+		}
+	}
+	return reminders, nil
+}
+
+// ReadFrom adds the reminders of a snapshot. The whole snapshot is parsed
+// before any of its reminders is added, so nothing is added, and no timer
+// started, if it is incomplete or malformed.
+func (rs *remindmeState) ReadFrom(r io.Reader) (int64, error) {
+	bb := new(bytes.Buffer)
+	n, err := bb.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	reminders, err := readSnapshot(bb)
+	if err != nil {
+		return n, err
+	}
+	rs.Lock()
+	rs.addAll(reminders)
+	rs.Unlock()
+	return n, nil
+}
+
+// WriteTo writes a snapshot of the reminders.
+func (rs *remindmeState) WriteTo(w io.Writer) (int64, error) {
+	return rs.writeSnapshot(w, nil)
+}
+
+// reset stops all timers and clears the state.
+func (rs *remindmeState) reset() {
+	rs.Lock()
+	defer rs.Unlock()
+	for i := range rs.reminders {
+		rs.reminders[i] = nil
+	}
+	rs.reminders = rs.reminders[:0]
+	for i := range rs.timers {
+		rs.timers[i].Stop()
+		rs.timers[i] = nil
+	}
+	rs.timers = rs.timers[:0]
+	for _, t := range rs.warnings {
+		t.Stop()
+	}
+	rs.warnings = nil
+	for _, t := range rs.triggers {
+		t.timer.Stop()
+	}
+	rs.triggers = nil
+	rs.byUser = nil
+	rs.byID = nil
+	// Both point at reminders that are no longer in the state.
+	rs.fired = nil
+	rs.acks = nil
+}
+
+func constructRMState(s *discordgo.Session) error {
+	rmState.session = s
+	if dbPath != "" {
+		return rmState.openDB(dbPath)
+	}
+	err := loadRMFiles()
+	if err != nil {
+		return err
+	}
+	err = rmState.openJournal()
+	if err != nil {
+		return err
+	}
+	rmState.startSaver()
+	return nil
+}
+
+// loadRMFiles loads the state from the journal or, failing that, from the
+// newest CSV snapshot.
+func loadRMFiles() error {
+	journalPath := filepath.Join(remindersDirname, journalFilename)
+	journalFile, err := os.Open(journalPath)
+	if err == nil {
+		err = rmState.replay(journalFile)
+		journalFile.Close()
+		if err == nil {
+			return nil
+		}
+		rmState.reset()
+		logger.Error("unable to replay reminders journal: ", err)
+		brokenPath := journalPath + ".broken-" + time.Now().In(time.UTC).Format(time.RFC3339)
+		err = os.Rename(journalPath, brokenPath)
+		if err != nil {
+			return fmt.Errorf("unable to set aside reminders journal: %v", err)
+		}
+		logger.Info("Moved reminders journal to ", brokenPath)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to open reminders journal: %v", err)
+	}
+	err = importRMSnapshot()
+	if err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// isSnapshotName reports whether name is that of a snapshot file or of a
+// sharded snapshot.
+func isSnapshotName(name string) bool {
+	return strings.HasPrefix(name, remindersFilePrefix) &&
+		(strings.HasSuffix(name, remindersFileSuffix) || strings.HasSuffix(name, shardsSuffix))
+}
+
+// importRMSnapshot loads the state from the newest complete CSV snapshot.
+// Having no reminders directory or no snapshots in it, as on the first run,
+// leaves the state empty without error; the directory is created once
+// there is something to store in it.
+func importRMSnapshot() error {
+	remindersDir, err := os.Open(remindersDirname)
+	if os.IsNotExist(err) {
+		logger.Info("No reminders directory, starting with no reminders.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open reminders directory: %v", err)
+	}
+	defer remindersDir.Close()
+	timezonesFile, err := os.Open(filepath.Join(remindersDirname, timezonesFilename))
+	if err == nil {
+		err = rmState.readZones(timezonesFile)
+		timezonesFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import timezones file: ", err)
+	}
+	prefixesFile, err := os.Open(filepath.Join(remindersDirname, prefixesFilename))
+	if err == nil {
+		err = rmState.readPrefixes(prefixesFile)
+		prefixesFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import prefixes file: ", err)
+	}
+	pausesFile, err := os.Open(filepath.Join(remindersDirname, pausesFilename))
+	if err == nil {
+		err = rmState.readPauses(pausesFile)
+		pausesFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import pauses file: ", err)
+	}
+	templatesFile, err := os.Open(filepath.Join(remindersDirname, templatesFilename))
+	if err == nil {
+		err = rmState.readTemplates(templatesFile)
+		templatesFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import templates file: ", err)
+	}
+	channelsFile, err := os.Open(filepath.Join(remindersDirname, channelsFilename))
+	if err == nil {
+		err = rmState.readChannels(channelsFile)
+		channelsFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import channels file: ", err)
+	}
+	triggersFile, err := os.Open(filepath.Join(remindersDirname, triggersFilename))
+	if err == nil {
+		err = rmState.readTriggers(triggersFile)
+		triggersFile.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("unable to import triggers file: ", err)
+	}
+	names, err := remindersDir.Readdirnames(0)
+	if err != nil {
+		return fmt.Errorf("unable to access reminders directory: %v", err)
+	}
+	var reminderFiles []string
+	for _, name := range names {
+		if isSnapshotName(name) {
+			reminderFiles = append(reminderFiles, name)
+		}
+	}
+	if len(reminderFiles) == 0 {
+		logger.Info("No reminder files found, starting with no reminders.")
+		return nil
+	}
+	// Fall back to older snapshots if newer ones are incomplete.
+	sort.Sort(sort.Reverse(sort.StringSlice(reminderFiles)))
+	for _, name := range reminderFiles {
+		if strings.HasSuffix(name, shardsSuffix) {
+			reminders, err := readShardedSnapshot(filepath.Join(remindersDirname, name))
+			if err != nil {
+				logger.Errorf("unable to import reminders from %s: %v", name, err)
+				continue
+			}
+			// addAll puts them in order whatever shard they came from.
+			rmState.Lock()
+			rmState.addAll(reminders)
+			rmState.Unlock()
+			logger.Infof("Imported reminders from %s", name)
+			return nil
+		}
+		remindersFile, err := os.Open(filepath.Join(remindersDirname, name))
+		if err != nil {
+			logger.Error("unable to open reminders file: ", err)
+			continue
+		}
+		_, err = rmState.ReadFrom(remindersFile)
+		remindersFile.Close()
+		if err != nil {
+			logger.Errorf("unable to import reminders file %s: %v", name, err)
+			continue
+		}
+		logger.Infof("Imported reminders from %s", name)
+		return nil
+	}
+	return fmt.Errorf("no complete reminder files found")
+}
+
+// reloadRMState replaces the state with the one stored, loading it the way
+// startup does: from the database in use, or else by replaying the journal,
+// which only falls back to the newest CSV snapshot if there is no journal
+// or it is broken. The journal is only rewritten from what was loaded, so
+// no change made since the last shutdown is lost.
+func reloadRMState() {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	rmState.reset()
+	rmState.Lock()
+	rmState.closeJournal()
+	// Keep the state being loaded from being stored again as it loads.
+	db := rmState.db
+	rmState.db = nil
+	rmState.zones = nil
+	rmState.prefixes = nil
+	rmState.paused = nil
+	rmState.templates = nil
+	rmState.channels = nil
+	rmState.Unlock()
+	if db != nil {
+		err := rmState.loadDB(db)
+		if err != nil {
+			logger.Error("unable to reload reminders database: ", err)
+		}
+		rmState.Lock()
+		rmState.db = db
+		rmState.Unlock()
+	} else {
+		err := loadRMFiles()
+		if err == nil {
+			err = rmState.openJournal()
+		}
+		if err != nil {
+			// Without having loaded the journal, rewriting it would
+			// throw it away.
+			logger.Error("unable to reload reminders, no longer journaling: ", err)
+		}
+	}
+	rmState.Lock()
+	logger.Infof("Reloaded %d reminders.", len(rmState.reminders))
+	rmState.Unlock()
+}
+
+// deconstructRMState stops all timers, flushes the journal and exports a
+// CSV snapshot of the state as a backup of it. Nothing depends on it
+// running: every change was already written to the journal or database as
+// it happened, so a process that is killed outright loses no reminders.
+func deconstructRMState() {
+	rmState.Lock()
+	close(rmState.done)
+	for _, timer := range rmState.timers {
+		timer.Stop()
+	}
+	for _, timer := range rmState.warnings {
+		timer.Stop()
+	}
+	for _, t := range rmState.triggers {
+		t.timer.Stop()
+	}
+	rmState.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		rmState.deliveries.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(shutdownTimeout):
+		logger.Error("timed out waiting for reminder deliveries to finish")
+	}
+	rmState.flushSaves()
+	rmState.Lock()
+	rmState.closeJournal()
+	rmState.closeDB()
+	rmState.Unlock()
+	err := os.Mkdir(remindersDirname, 0700)
+	if err != nil && !os.IsExist(err) {
+		logger.Error("unable to create reminders directory", err)
+		logger.Error("aborting records to stderr")
+		rmState.WriteTo(os.Stderr)
+		return
+	}
+	snapshotName := filepath.Join(remindersDirname, remindersFilePrefix) +
+		time.Now().In(time.UTC).Format(time.RFC3339)
+	err = rmState.writeShardedSnapshot(snapshotName + shardsSuffix)
+	if err != nil {
+		logger.Error("unable to export sharded reminders, exporting a single file: ", err)
+		err = writeFileAtomic(snapshotName+remindersFileSuffix, func(w io.Writer) error {
+			_, err := rmState.WriteTo(w)
+			return err
+		})
+	}
+	if err != nil {
+		logger.Error("error exporting reminders: ", err)
+		logger.Error("aborting records to stderr")
+		rmState.WriteTo(os.Stderr)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, timezonesFilename), rmState.writeZones)
+	if err != nil {
+		logger.Error("error exporting timezones: ", err)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, prefixesFilename), rmState.writePrefixes)
+	if err != nil {
+		logger.Error("error exporting prefixes: ", err)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, pausesFilename), rmState.writePauses)
+	if err != nil {
+		logger.Error("error exporting pauses: ", err)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, templatesFilename), rmState.writeTemplates)
+	if err != nil {
+		logger.Error("error exporting templates: ", err)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, channelsFilename), rmState.writeChannels)
+	if err != nil {
+		logger.Error("error exporting channels: ", err)
+	}
+	err = writeFileAtomic(filepath.Join(remindersDirname, triggersFilename), rmState.writeTriggers)
+	if err != nil {
+		logger.Error("error exporting triggers: ", err)
+	}
+}
+
+// writeFileAtomic replaces the file name with the output of write. The
+// output is synced to a temporary file beside name, which is then renamed
+// over it, so that name is never left partially written.
+func writeFileAtomic(name string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// pruneFiles deletes the files in dir that match, except for the keepFiles
+// newest by name and any modified within fileRetention. Matching
+// directories, such as sharded snapshots, are deleted with their contents.
+func pruneFiles(dir string, match func(name string) bool) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logger.Errorf("unable to prune %s: %v", dir, err)
+		return
+	}
+	var files []os.FileInfo
+	for _, info := range infos {
+		if (info.Mode().IsRegular() || info.IsDir()) && match(info.Name()) {
+			files = append(files, info)
+		}
+	}
+	// ReadDir sorts by name, which for timestamped names is oldest first.
+	if len(files) <= keepFiles {
+		return
+	}
+	pruned := 0
+	for _, info := range files[:len(files)-keepFiles] {
+		if time.Since(info.ModTime()) < fileRetention {
+			continue
+		}
+		err := os.RemoveAll(filepath.Join(dir, info.Name()))
+		if err != nil {
+			logger.Error("unable to prune old file: ", err)
+			continue
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		logger.Infof("Pruned %d old files from %s", pruned, dir)
+	}
+}
+
+// checkDuration reports whether d is usable as the delay of a reminder.
+func checkDuration(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if d > maxDuration {
+		return fmt.Errorf("duration must not exceed %v", maxDuration)
+	}
+	return nil
+}
+
+// parseReminderDuration parses a duration given by a user for a reminder.
+func parseReminderDuration(arg string) (time.Duration, error) {
+	d, err := parseDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("%s; durations combine numbers with the units s, m, h, d, w and y, like 1h30m, 2d12h or 1.5h",
+			strings.TrimPrefix(err.Error(), "time: "))
+	}
+	return d, checkDuration(d)
+}
+
+// prepareReminder checks r, set for target by author, and fills in who and
+// when it was set by. The error, if any, is fit to show to author.
+func prepareReminder(author, target *discordgo.User, r *reminder) error {
+	if target.Bot {
+		return fmt.Errorf("bots cannot be reminded")
+	}
+	err := checkMessageLen(r.message)
+	if err != nil {
+		return err
+	}
+	r.userID = target.ID
+	r.authorID = author.ID
+	r.creation = rmState.now()
+	r.expiration = r.expiration.In(time.UTC)
+	if loc := rmState.Zone(author.ID); loc != time.UTC {
+		r.zone = loc.String()
+	}
+	return nil
+}
+
+// checkMessageLen checks that message, for a new or edited reminder, is at
+// most maxReminderLen characters long, as a longer one could fail to be
+// delivered. The error, if any, is fit to show to the author.
+func checkMessageLen(message string) error {
+	if n := utf8.RuneCountInString(message); n > maxReminderLen {
+		return fmt.Errorf("that message is %d characters long, but may be at most %d",
+			n, maxReminderLen)
+	}
+	return nil
+}
+
+// tooManyReminders returns the error shown to author when target already
+// has the maximum number of reminders.
+func tooManyReminders(author, target *discordgo.User) error {
+	if target.ID == author.ID {
+		return fmt.Errorf("you already have the maximum of %d reminders", maxReminders)
+	}
+	return fmt.Errorf("%s already has the maximum of %d reminders",
+		target.Username, maxReminders)
+}
+
+// setReminder schedules r for target on behalf of author. r need only have
+// its expiration and message set, along with any optional fields; the rest
+// are filled in. The error, if any, is fit to show to author.
+func setReminder(author, target *discordgo.User, r *reminder) (*reminder, error) {
+	err := prepareReminder(author, target, r)
+	if err != nil {
+		return nil, err
+	}
+	switch rmState.Add(r, maxReminders) {
+	case nil:
+	case errDuplicate:
+		if target.ID == author.ID {
+			return nil, fmt.Errorf("you already have that reminder")
+		}
+		return nil, fmt.Errorf("%s already has that reminder", target.Username)
+	case errTooManyReminders:
+		return nil, tooManyReminders(author, target)
+	case errNotFound:
+		return nil, fmt.Errorf("the reminder to go off after is gone")
+	}
+	logger.User(target.ID).Infof("Set reminder %s for %s by %s to go off %s with the message %q",
+		r.id, (*userLog)(target), (*userLog)(author), r.expiration, r.message)
+	return r, nil
+}
+
+// formatUntil describes how far off a reminder due in d is, to the minute.
+func formatUntil(d time.Duration) string {
+	if d <= 0 {
+		return "firing now"
+	}
+	return "in " + humanizeDuration(d)
+}
+
+// humanizeDuration describes d, which must be positive, in days, hours and
+// minutes, leaving out those that are zero, like "2 days, 3 hours". Less
+// than a minute is "less than a minute".
+func humanizeDuration(d time.Duration) string {
+	var parts []string
+	add := func(n time.Duration, unit string) {
+		switch {
+		case n == 1:
+			parts = append(parts, "1 "+unit)
+		case n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit))
+		}
+	}
+	add(d/day, "day")
+	add(d%day/time.Hour, "hour")
+	add(d%time.Hour/time.Minute, "minute")
+	if len(parts) == 0 {
+		return "less than a minute"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// paginate joins rows into messages of at most maxMessageLen characters,
+// each starting with header. Rows are never split across messages; a row
+// too long to fit in a message by itself is truncated.
+func paginate(header string, rows []string) []string {
+	var pages []string
+	page := new(strings.Builder)
+	pageLen := 0
+	for _, row := range rows {
+		rowLen := utf8.RuneCountInString(row)
+		if limit := maxMessageLen - utf8.RuneCountInString(header); rowLen > limit {
+			row = truncate(row, limit-1) + "\n"
+			rowLen = limit
+		}
+		if pageLen > 0 && pageLen+rowLen > maxMessageLen {
+			pages = append(pages, page.String())
+			page.Reset()
+			pageLen = 0
+		}
+		if pageLen == 0 {
+			page.WriteString(header)
+			pageLen = utf8.RuneCountInString(header)
+		}
+		page.WriteString(row)
+		pageLen += rowLen
+	}
+	if pageLen > 0 {
+		pages = append(pages, page.String())
+	}
+	return pages
+}
+
+// truncate shortens s to at most n runes, marking any cut with an ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// splitMessage splits s into pieces of at most maxMessageLen runes.
+func splitMessage(s string) []string {
+	if utf8.RuneCountInString(s) <= maxMessageLen {
+		return []string{s}
+	}
+	var chunks []string
+	runes := []rune(s)
+	for len(runes) > maxMessageLen {
+		chunks = append(chunks, string(runes[:maxMessageLen]))
+		runes = runes[maxMessageLen:]
+	}
+	return append(chunks, string(runes))
+}
+
+// listReminders formats userID's reminders for display in loc as a series
+// of messages, or returns nil if they have none. If tag is not empty, only
+// reminders with that tag are listed. A nil loc shows each reminder in the
+// timezone it was set in.
+func listReminders(userID string, tag string, loc *time.Location) []string {
+	var reminders []reminder
+	rmState.Lock()
+	for _, r := range rmState.byUser[userID] {
+		if tag == "" || r.hasTag(tag) {
+			reminders = append(reminders, *r)
+		}
+	}
+	rmState.Unlock()
+	return formatReminders(reminders, loc, false)
+}
+
+// listBetween is like listReminders for userID's reminders going off from
+// start to end, both included. A zero start or end leaves that end open.
+func listBetween(userID string, start, end time.Time) []string {
+	var reminders []reminder
+	rmState.Lock()
+	for _, r := range rmState.byUser[userID] {
+		if (start.IsZero() || !r.expiration.Before(start)) && (end.IsZero() || !r.expiration.After(end)) {
+			reminders = append(reminders, *r)
+		}
+	}
+	rmState.Unlock()
+	return formatReminders(reminders, rmState.Zone(userID), false)
+}
+
+// listSentReminders is like listReminders for the reminders authorID has
+// set for other users.
+func listSentReminders(authorID string, tag string, loc *time.Location) []string {
+	var reminders []reminder
+	rmState.Lock()
+	for _, r := range rmState.reminders {
+		if r.authorID == authorID && r.userID != authorID && (tag == "" || r.hasTag(tag)) {
+			reminders = append(reminders, *r)
+		}
+	}
+	rmState.Unlock()
+	return formatReminders(reminders, loc, true)
+}
+
+// listAllReminders is like listReminders for the reminders of every user.
+func listAllReminders(tag string, loc *time.Location) []string {
+	var reminders []reminder
+	rmState.Lock()
+	for _, r := range rmState.reminders {
+		if tag == "" || r.hasTag(tag) {
+			reminders = append(reminders, *r)
+		}
+	}
+	rmState.Unlock()
+	return formatReminders(reminders, loc, true)
+}
+
+// formatMessage returns r's message followed by its tags.
+func formatMessage(r *reminder) string {
+	if len(r.tags) == 0 {
+		return r.message
+	}
+	return r.message + " #" + strings.Join(r.tags, " #")
+}
+
+// formatReminders formats reminders in loc, or each in the timezone it was
+// set in if loc is nil, next to go off first. If sent, who each reminder is
+// for is shown in place of its creation.
+func formatReminders(reminders []reminder, loc *time.Location, sent bool) []string {
+	if len(reminders) == 0 {
+		return nil
+	}
+	sort.SliceStable(reminders, func(a, b int) bool {
+		return reminders[a].expiration.Before(reminders[b].expiration)
+	})
+	const listFmt = "`%s` :small_blue_diamond: `%s` :small_blue_diamond: `%s` :small_blue_diamond: `%s` :small_blue_diamond: `%s`\n"
+	header := fmt.Sprintf(listFmt, "id", "creation", "expiration", "fires", "message")
+	if sent {
+		header = fmt.Sprintf(listFmt, "id", "for", "expiration", "fires", "message")
+	}
+	rows := make([]string, len(reminders))
+	for k, r := range reminders {
+		loc := loc
+		if loc == nil {
+			loc = r.createdIn()
+		}
+		second := r.creation.In(loc).Format(time.RFC3339Nano)
+		if sent {
+			second = "<@" + r.userID + ">"
+		}
+		fires := formatUntil(rmState.until(r.expiration))
+		if r.pending {
+			fires = "awaiting delivery"
+		}
+		if r.daily != "" {
+			fires += ", daily at " + r.daily
+		}
+		if r.priority != normalPriority {
+			fires += ", " + formatPriority(r.priority) + " priority"
+		}
+		rows[k] = fmt.Sprintf(listFmt,
+			r.id,
+			second,
+			r.expiration.In(loc).Format(time.RFC3339Nano),
+			fires,
+			formatLabel(&r),
+		)
+	}
+	return paginate(header, rows)
+}
+
+// parseNewReminder parses the words of a new reminder: when it goes off, as
+// understood by parseWhen, followed by its message. It returns the message
+// words without the tags among them.
+func parseNewReminder(words []string, now time.Time) (expiration time.Time, message, tags []string, err error) {
+	expiration, n, err := parseWhen(words, now)
+	if err != nil {
+		return time.Time{}, nil, nil, err
+	}
+	message, tags = parseTags(words[n:])
+	if blankMessage(message) {
+		return time.Time{}, nil, nil, fmt.Errorf("missing message")
+	}
+	return expiration, message, tags, nil
+}
+
+// splitItems splits s into the items of a batch, each in double quotes.
+func splitItems(s string) ([]string, error) {
+	var items []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return items, nil
+		}
+		open, size := utf8.DecodeRuneInString(s)
+		if open != '"' && open != '“' {
+			return nil, fmt.Errorf("put each reminder in double quotes")
+		}
+		s = s[size:]
+		end := strings.IndexAny(s, `"”`)
+		if end == -1 {
+			return nil, fmt.Errorf("missing closing quote")
+		}
+		items = append(items, s[:end])
+		_, size = utf8.DecodeRuneInString(s[end:])
+		s = s[end+size:]
+	}
+}
+
+func newRemindmeParser(s *discordgo.Session, channelID string) *docopt.Parser {
+	parser := new(docopt.Parser)
+	parser.HelpHandler = func(err error, usage string) {
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		buflen := len(errStr) + len(usage)
+		buf := make([]byte, buflen)
+		n := copy(buf, errStr)
+		copy(buf[n:], usage)
+		sendMsg(s, channelID, string(buf))
+	}
+	return parser
+}
+
+func remindmeHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	const remindmeUsage = `
+Usage:
+	!remindme list [--sent | --all] [--here] [--orig-tz] [<tag>]
+	!remindme count
+	!remindme next [<n>]
+	!remindme when <query>...
+	!remindme between <range>...
+	!remindme cancel (<id> | --all | --last | --at <when>... | --match <text>...)
+	!remindme snooze <id> <duration>
+	!remindme snooze-soon <duration>
+	!remindme give <id> <user>
+	!remindme repeat <id> <duration>
+	!remindme after <id> <duration> [--here] [--confirm] [--silent] <message>...
+	!remindme edit <id> [--in=<duration>] [<message>...]
+	!remindme shift [--] <offset>
+	!remindme save [--replace] <name> <message>...
+	!remindme use <name> <when>...
+	!remindme templates
+	!remindme forget <name>
+	!remindme pause
+	!remindme resume
+	!remindme preview <when>...
+	!remindme timezone <zone>
+	!remindme deliver (here | dm)
+	!remindme prefix <prefix>
+	!remindme batch <item>...
+	!remindme broadcast <message>...
+	!remindme daily <time> [-c|--withcontext] [--quote] [--here] [--warn=<duration>] [--priority=<level>] [--label=<label>] [--attach=<url>]... [--confirm] [--silent] <message>...
+	!remindme <duration> [-c|--withcontext] [--quote] [--here] [--warn=<duration>] [--priority=<level>] [--label=<label>] [--attach=<url>]... [--after-reply] [--confirm] [--silent] <message>...
+
+<duration> may instead be a time, like "tomorrow at 9am", "friday 5pm",
+"2024-06-01 14:30" or "2024-06-01T14:30:00Z", in your timezone.
+Mention someone before <duration> to remind them instead of yourself.
+Mentioning the bot instead of writing !remindme works too.
+With --quote, in reply to a message, the reminder quotes that message when
+it goes off, or links it if it is gone by then.
+With --here, the reminder is posted in this channel instead of sent to you.
+With --warn, like --warn=10m, the bot also messages you that long before
+the reminder goes off.
+With --priority, low, normal or high, reminders going off together are
+delivered in order of priority; normal is the default.
+With --label, like --label="pay rent", the reminder is listed as the label
+instead of its message, which is still what you get when it goes off.
+With --attach, like --attach=https://example.com/cat.png, the reminder
+comes with a link or image; repeat it for more.
+With --after-reply, <duration> only starts once someone replies to your
+message. If nobody does within a while, the reminder is dropped; deleting
+your message or cancelling the reminder drops it as well.
+With --confirm, the bot replies with when the reminder will go off.
+With --silent, the bot does not react to your message.
+Words of the message like #work tag the reminder, and list #work lists only
+reminders tagged so. Write \#word for a word that is not a tag.
+batch sets several reminders at once, each in double quotes and written
+like the rest of a new reminder, as in batch "1h call mom" "2d pay rent".
+broadcast sends a message to everyone with reminders; only the bot's owner
+may use it.
+list --orig-tz shows each reminder in the timezone it was set in rather
+than in yours.
+list --all lists everyone's reminders; only the bot's owner may use it.
+daily sets a reminder that goes off every day at <time>, like 8am or 08:00.
+between lists your reminders going off from one time to another, both
+included, like between tomorrow and friday 5pm. Write - for either end to
+leave it open, as in between - and 2024-06-01.
+cancel --at cancels the reminder going off at a time like "friday 5pm",
+give or take a minute.
+snooze-soon pushes back all your reminders going off within the hour by
+<duration>; any already going off still do.
+repeat sets a new reminder with the message of an existing one, or one that
+went off within the hour, to go off after <duration>.
+after sets a reminder to go off <duration> after your reminder <id>. If
+that one is moved by edit or snooze, so is this one, and if it is
+cancelled, this one is too; once it goes off, this one stays as it is.
+give hands one of your reminders over to the user mentioned as <user>.
+shift moves all your reminders by <offset>, like 1h or -30m; any that end
+up in the past go off right away.
+save keeps a message as a template called <name>, and use sets a reminder
+with it, like use standup tomorrow 9am. forget deletes a template.
+pause keeps your reminders from going off until resume; any that came due
+meanwhile go off when you resume.
+deliver here has your reminders posted in this channel, mentioning you,
+instead of sent to you; if the bot cannot post there, they are sent to you
+after all. deliver dm goes back to sending them. Reminders set with --here
+always go to the channel they were set in.
+`
+	// A bug hit by one command should not take the whole bot down.
+	defer func() {
+		if err := recover(); err != nil {
+			logger.User(m.Author.ID).Errorf("recovered from panic handling message %s from %s: %v\n%s",
+				m.ID, (*userLog)(m.Author), err, debug.Stack())
+			addReaction(s, m.ChannelID, m.ID, "⚠️")
+		}
+	}()
+	m.Content = strings.TrimLeftFunc(m.Content, unicode.IsSpace)
+	prefix := rmState.Prefix(m.GuildID)
+	argv := strings.Fields(m.Content)
+	if len(argv) == 0 {
+		return
+	}
+	// Mentioning the bot works as well as the prefix.
+	botID := s.State.User.ID
+	if argv[0] != prefix && argv[0] != "<@"+botID+">" && argv[0] != "<@!"+botID+">" {
+		return
+	}
+	if !allowCommand(m.Author.ID, rmState.now()) {
+		logger.User(m.Author.ID).Infof("Rate limited command from %s", (*userLog)(m.Author))
+		addReaction(s, m.ChannelID, m.ID, "⏳")
+		return
+	}
+	usage := strings.Replace(remindmeUsage, defaultPrefix, prefix, -1)
+	// docopt cannot tell an optional leading mention from <duration>, so
+	// take it out beforehand.
+	var target *discordgo.User
+	if len(argv) > 1 {
+		for _, u := range m.Mentions {
+			if argv[1] == "<@"+u.ID+">" || argv[1] == "<@!"+u.ID+">" {
+				target = u
+				argv = append(argv[:1], argv[2:]...)
+				break
+			}
+		}
+	}
+	// A negative offset would be taken for an option.
+	if len(argv) > 2 && argv[1] == "shift" && strings.HasPrefix(argv[2], "-") && argv[2] != "--" {
+		argv = append(argv[:2], append([]string{"--"}, argv[2:]...)...)
+	}
+	argv = joinLabel(argv)
+	parser := newRemindmeParser(s, m.ChannelID)
+	opts, err := parser.ParseArgs(usage, argv[1:], "")
+	if err != nil {
+		if _, ok := err.(*docopt.UserError); !ok {
 			logger.Panic("invalid option parser: ", err)
 		}
 		return
 	}
 	var remindmeConfig struct {
 		List        bool
+		Sent        bool `docopt:"--sent"`
+		OrigTZ      bool `docopt:"--orig-tz"`
+		Tag         string
+		Count       bool
+		Next        bool
+		N           string `docopt:"<n>"`
+		WhenCmd     bool   `docopt:"when"`
+		Query       []string
+		Between     bool
+		Range       []string
 		Cancel      bool
-		Expiration  string
+		All         bool `docopt:"--all"`
+		Match       bool `docopt:"--match"`
+		Last        bool `docopt:"--last"`
+		At          bool `docopt:"--at"`
+		Text        []string
+		Snooze      bool
+		SnoozeSoon  bool `docopt:"snooze-soon"`
+		Give        bool
+		Repeat      bool
+		AfterCmd    bool   `docopt:"after"`
+		User        string `docopt:"<user>"`
+		Edit        bool
+		In          string `docopt:"--in"`
+		Shift       bool
+		Offset      string
+		DoubleDash  bool `docopt:"--"`
+		Save        bool
+		Replace     bool `docopt:"--replace"`
+		Name        string
+		Use         bool
+		Templates   bool
+		Forget      bool
+		Pause       bool
+		Resume      bool
+		Preview     bool
+		When        []string
+		Timezone    bool
+		Zone        string
+		Deliver     bool
+		HereCmd     bool `docopt:"here"`
+		DM          bool `docopt:"dm"`
+		Prefix      bool
+		NewPrefix   string `docopt:"<prefix>"`
+		Batch       bool
+		Broadcast   bool
+		Item        []string
+		Daily       bool
+		Time        string `docopt:"<time>"`
+		ID          string `docopt:"<id>"`
 		Duration    string
-		WithContext bool `docopt:"-c,--withcontext"`
+		WithContext bool     `docopt:"-c,--withcontext"`
+		Quote       bool     `docopt:"--quote"`
+		Here        bool     `docopt:"--here"`
+		Warn        string   `docopt:"--warn"`
+		Priority    string   `docopt:"--priority"`
+		Label       string   `docopt:"--label"`
+		Attach      []string `docopt:"--attach"`
+		AfterReply  bool     `docopt:"--after-reply"`
+		Confirm     bool     `docopt:"--confirm"`
+		Silent      bool     `docopt:"--silent"`
 		Message     []string
 	}
 	err = opts.Bind(&remindmeConfig)
@@ -316,155 +2628,961 @@ Usage:
 		logger.Panic("unable to bind options: ", err)
 		return
 	}
-	logger.Printf("User %s sent command \"%s\"", (*userLog)(m.Author), m.Content)
+	logger.User(m.Author.ID).Infof("User %s sent command \"%s\"", (*userLog)(m.Author), m.Content)
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	isCreate := !(remindmeConfig.List || remindmeConfig.Count || remindmeConfig.Next ||
+		remindmeConfig.WhenCmd || remindmeConfig.Between ||
+		remindmeConfig.Cancel || remindmeConfig.Snooze || remindmeConfig.SnoozeSoon ||
+		remindmeConfig.Repeat ||
+		remindmeConfig.AfterCmd ||
+		remindmeConfig.Give ||
+		remindmeConfig.Edit ||
+		remindmeConfig.Shift || remindmeConfig.Pause || remindmeConfig.Resume ||
+		remindmeConfig.Save || remindmeConfig.Templates || remindmeConfig.Forget ||
+		remindmeConfig.Preview ||
+		remindmeConfig.Timezone || remindmeConfig.Deliver ||
+		remindmeConfig.Prefix || remindmeConfig.Batch ||
+		remindmeConfig.Broadcast)
+	if target != nil && !isCreate {
+		parser.HelpHandler(fmt.Errorf("a mention only applies to new reminders"), usage)
+		return
+	}
 	switch {
 	case remindmeConfig.List:
-		authorID := m.Author.ID
+		tag := strings.TrimPrefix(remindmeConfig.Tag, "#")
+		loc := rmState.Zone(m.Author.ID)
+		if remindmeConfig.OrigTZ {
+			loc = nil
+		}
+		var pages []string
+		switch {
+		case remindmeConfig.All:
+			if ownerID == "" || m.Author.ID != ownerID {
+				sendMsg(s, m.ChannelID, "only the bot's owner may list everyone's reminders")
+				return
+			}
+			logger.User(m.Author.ID).Infof("Listing all reminders for %s", (*userLog)(m.Author))
+			pages = listAllReminders(tag, loc)
+		case remindmeConfig.Sent:
+			pages = listSentReminders(m.Author.ID, tag, loc)
+		default:
+			pages = listReminders(m.Author.ID, tag, loc)
+		}
+		if len(pages) == 0 {
+			if remindmeConfig.All {
+				sendMsg(s, m.ChannelID, "there are no reminders")
+			} else if remindmeConfig.Sent {
+				sendMsg(s, m.ChannelID, "you have no reminders set for others")
+			} else {
+				sendMsg(s, m.ChannelID, "you have no reminders")
+			}
+			return
+		}
+		if remindmeConfig.Here {
+			for _, page := range pages {
+				sendMsg(s, m.ChannelID, page)
+			}
+			return
+		}
+		dm, err := s.UserChannelCreate(m.Author.ID)
+		if err == nil {
+			for _, page := range pages {
+				_, err = sendChunks(s, dm.ID, page, noMentions)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logger.User(m.Author.ID).Errorf("unable to send list to %s: %v", (*userLog)(m.Author), err)
+			sendMsg(s, m.ChannelID, fmt.Sprintf(
+				"I could not message you; allow direct messages or use `%s list --here`", prefix))
+		}
+	case remindmeConfig.Count:
 		rmState.Lock()
-		defer rmState.Unlock()
-		i := sort.Search(len(rmState.reminders), func(i int) bool {
-			return rmState.reminders[i].userID >= authorID
-		})
-		j := sort.Search(len(rmState.reminders), func(i int) bool {
-			return rmState.reminders[i].userID > authorID
-		})
-		if j - i == 0 {
+		n := len(rmState.byUser[m.Author.ID])
+		rmState.Unlock()
+		sendMsg(s, m.ChannelID, fmt.Sprintf("you have %d of at most %d reminders", n, maxReminders))
+	case remindmeConfig.Next:
+		n := defaultNext
+		if remindmeConfig.N != "" {
+			n, err = strconv.Atoi(remindmeConfig.N)
+			if err != nil || n < 1 || n > maxNext {
+				parser.HelpHandler(fmt.Errorf("<n> must be a whole number from 1 to %d", maxNext), usage)
+				return
+			}
+		}
+		// A user's reminders are stored soonest first.
+		rmState.Lock()
+		mine := rmState.byUser[m.Author.ID]
+		if len(mine) > n {
+			mine = mine[:n]
+		}
+		next := make([]reminder, len(mine))
+		for k, r := range mine {
+			next[k] = *r
+		}
+		rmState.Unlock()
+		pages := formatReminders(next, rmState.Zone(m.Author.ID), false)
+		if len(pages) == 0 {
+			sendMsg(s, m.ChannelID, "you have no reminders")
+			return
+		}
+		for _, page := range pages {
+			sendMsg(s, m.ChannelID, page)
+		}
+	case remindmeConfig.Between:
+		loc := rmState.Zone(m.Author.ID)
+		start, end, err := parseRange(remindmeConfig.Range, rmState.clock.Now().In(loc))
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		pages := listBetween(m.Author.ID, start, end)
+		if len(pages) == 0 {
+			sendMsg(s, m.ChannelID, "you have no reminders then")
+			return
+		}
+		for _, page := range pages {
+			sendMsg(s, m.ChannelID, page)
+		}
+	case remindmeConfig.WhenCmd:
+		// An ID is looked for first, then the query as part of a message.
+		query := strings.Join(remindmeConfig.Query, " ")
+		var matches []reminder
+		rmState.Lock()
+		for _, r := range rmState.byUser[m.Author.ID] {
+			if r.id == strings.ToLower(query) {
+				matches = append(matches, *r)
+			}
+		}
+		rmState.Unlock()
+		if len(matches) == 0 {
+			matches = rmState.Matching(m.Author.ID, query)
+		}
+		loc := rmState.Zone(m.Author.ID)
+		switch len(matches) {
+		case 0:
+			sendMsg(s, m.ChannelID, "no reminders match")
+		case 1:
+			r := matches[0]
+			fires := formatUntil(rmState.until(r.expiration))
+			if r.pending {
+				fires = "awaiting delivery"
+			}
+			sendMsg(s, m.ChannelID, fmt.Sprintf("`%s` goes off at %s, %s: %s",
+				r.id, r.expiration.In(loc).Format(displayTimeFmt), fires, formatLabel(&r)))
+		default:
+			sendMsg(s, m.ChannelID, fmt.Sprintf("%d reminders match:", len(matches)))
+			for _, page := range formatReminders(matches, loc, false) {
+				sendMsg(s, m.ChannelID, page)
+			}
+		}
+	case remindmeConfig.Cancel && remindmeConfig.All:
+		rmState.Lock()
+		n := len(rmState.byUser[m.Author.ID])
+		rmState.Unlock()
+		if n == 0 {
 			sendMsg(s, m.ChannelID, "you have no reminders")
 			return
 		}
-		dm, err := s.UserChannelCreate(authorID)
+		dm, err := s.UserChannelCreate(m.Author.ID)
+		if err != nil {
+			logger.User(m.Author.ID).Errorf("unable to open private channel with %s for cancel command: %v",
+				(*userLog)(m.Author), err)
+			sendMsgCmplx(s, m.ChannelID, internalErrMsg)
+			return
+		}
+		prompt := fmt.Sprintf("React with ✅ within %v to cancel all %d of your reminders.",
+			confirmTimeout, n)
+		err = confirm(s, dm.ID, m.Author.ID, prompt, func() {
+			reloadLock.RLock()
+			defer reloadLock.RUnlock()
+			removed, firing := rmState.RemoveAll(m.Author.ID)
+			reply := fmt.Sprintf("cancelled %d reminders", removed)
+			if firing > 0 {
+				reply += fmt.Sprintf(" (%d already going off)", firing)
+			}
+			sendMsg(s, dm.ID, reply)
+		})
 		if err != nil {
-			logger.Printf("unable to open private channel with %s for list command: %v",
+			logger.User(m.Author.ID).Errorf("unable to ask %s to confirm cancelling all reminders: %v",
 				(*userLog)(m.Author), err)
+			sendMsgCmplx(s, m.ChannelID, internalErrMsg)
+		}
+	case remindmeConfig.Cancel && remindmeConfig.Match:
+		// Only cancel a match if it is the only one, so that a vague text
+		// cannot cancel more than meant.
+		text := strings.Join(remindmeConfig.Text, " ")
+		matches := rmState.Matching(m.Author.ID, text)
+		switch len(matches) {
+		case 0:
+			sendMsg(s, m.ChannelID, "no reminders match")
+		case 1:
+			if err := rmState.Remove(m.Author.ID, matches[0].id); err != nil {
+				cancelFailed(s, m, err)
+			} else {
+				sendMsg(s, m.ChannelID, fmt.Sprintf("cancelled reminder `%s`", matches[0].id))
+			}
+		default:
+			sendMsg(s, m.ChannelID, fmt.Sprintf("%d reminders match; cancel the one you mean by its id:",
+				len(matches)))
+			for _, page := range formatReminders(matches, rmState.Zone(m.Author.ID), false) {
+				sendMsg(s, m.ChannelID, page)
+			}
+		}
+	case remindmeConfig.Cancel && remindmeConfig.At:
+		now := rmState.clock.Now().In(rmState.Zone(m.Author.ID))
+		t, n, err := parseTime(remindmeConfig.When, now)
+		if err == nil && n != len(remindmeConfig.When) {
+			err = fmt.Errorf("unexpected %q", strings.Join(remindmeConfig.When[n:], " "))
+		}
+		if err != nil {
+			parser.HelpHandler(err, usage)
 			return
 		}
-		const listFmt = "`%s` :small_blue_diamond: `%s` :small_blue_diamond: `%s`\n"
-		list := new(strings.Builder)
-		list.WriteString(fmt.Sprintf(listFmt, "creation", "expiration", "message"))
-		for _, r := range rmState.reminders[i:j] {
-			list.WriteString(fmt.Sprintf(listFmt,
-				r.creation.Format(time.RFC3339Nano),
-				r.expiration.Format(time.RFC3339Nano),
-				r.message,
-			))
+		// As with --match, only cancel a reminder if it is the only one
+		// near enough.
+		near := rmState.Near(m.Author.ID, t, cancelTolerance)
+		switch len(near) {
+		case 0:
+			sendMsg(s, m.ChannelID, fmt.Sprintf("no reminders go off around %s", t.Format(displayTimeFmt)))
+		case 1:
+			if err := rmState.Remove(m.Author.ID, near[0].id); err != nil {
+				cancelFailed(s, m, err)
+			} else {
+				sendMsg(s, m.ChannelID, fmt.Sprintf("cancelled reminder `%s`", near[0].id))
+			}
+		default:
+			sendMsg(s, m.ChannelID, fmt.Sprintf("%d reminders go off around then; cancel the one you mean by its id:",
+				len(near)))
+			for _, page := range formatReminders(near, now.Location(), false) {
+				sendMsg(s, m.ChannelID, page)
+			}
+		}
+	case remindmeConfig.Cancel && remindmeConfig.Last:
+		err := errNotFound
+		if id := rmState.Latest(m.Author.ID); id != "" {
+			err = rmState.Remove(m.Author.ID, id)
+		}
+		if err != nil {
+			cancelFailed(s, m, err)
+			return
 		}
-		sendMsg(s, dm.ID, list.String())
+		addReaction(s, m.ChannelID, m.ID, "✅")
 	case remindmeConfig.Cancel:
-		expiration, err := time.Parse(time.RFC3339Nano, remindmeConfig.Expiration)
+		id := strings.ToLower(remindmeConfig.ID)
+		if err := rmState.Remove(m.Author.ID, id); err != nil {
+			cancelFailed(s, m, err)
+			return
+		}
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Shift:
+		offset, err := parseDuration(remindmeConfig.Offset)
+		if err == nil && (offset == 0 || offset > maxDuration || offset < -maxDuration) {
+			err = fmt.Errorf("offset must be nonzero and at most %v either way", maxDuration)
+		}
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		shifted, firing := rmState.Shift(m.Author.ID, offset)
+		reply := fmt.Sprintf("shifted %d reminders", shifted)
+		if firing > 0 {
+			reply += fmt.Sprintf(" (%d already going off)", firing)
+		}
+		sendMsg(s, m.ChannelID, reply)
+	case remindmeConfig.Save:
+		name := strings.ToLower(remindmeConfig.Name)
+		if !templateNameRe.MatchString(name) {
+			parser.HelpHandler(fmt.Errorf("template names are up to 32 letters, digits, - and _"), usage)
+			return
+		}
+		if blankMessage(remindmeConfig.Message) {
+			parser.HelpHandler(fmt.Errorf("missing message"), usage)
+			return
+		}
+		if _, ok := rmState.Template(m.Author.ID, name); ok && !remindmeConfig.Replace {
+			sendMsg(s, m.ChannelID, fmt.Sprintf(
+				"you already have a template called %s; add --replace to replace it", name))
+			return
+		}
+		err := rmState.SetTemplate(m.Author.ID, name, strings.Join(remindmeConfig.Message, " "))
+		if err != nil {
+			sendMsg(s, m.ChannelID, err.Error())
+			return
+		}
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Use:
+		name := strings.ToLower(remindmeConfig.Name)
+		message, ok := rmState.Template(m.Author.ID, name)
+		if !ok {
+			sendMsg(s, m.ChannelID, fmt.Sprintf("you have no template called %s", name))
+			return
+		}
+		now := rmState.clock.Now().In(rmState.Zone(m.Author.ID))
+		expiration, n, err := parseWhen(remindmeConfig.When, now)
+		if err == nil && n != len(remindmeConfig.When) {
+			err = fmt.Errorf("unexpected %q", strings.Join(remindmeConfig.When[n:], " "))
+		}
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		if target == nil {
+			target = m.Author
+		}
+		words, tags := parseTags(strings.Fields(message))
+		_, err = setReminder(m.Author, target, &reminder{
+			expiration: expiration,
+			message:    strings.Join(words, " "),
+			tags:       tags,
+		})
+		if err != nil {
+			sendMsg(s, m.ChannelID, err.Error())
+			return
+		}
+		addReaction(s, m.ChannelID, m.ID, "🆗")
+	case remindmeConfig.Templates:
+		names := rmState.Templates(m.Author.ID)
+		if len(names) == 0 {
+			sendMsg(s, m.ChannelID, fmt.Sprintf("you have no templates; save one with `%s save`", prefix))
+			return
+		}
+		rows := make([]string, len(names))
+		for k, name := range names {
+			message, _ := rmState.Template(m.Author.ID, name)
+			rows[k] = fmt.Sprintf("`%s` :small_blue_diamond: %s\n", name, message)
+		}
+		for _, page := range paginate("", rows) {
+			sendMsg(s, m.ChannelID, page)
+		}
+	case remindmeConfig.Forget:
+		name := strings.ToLower(remindmeConfig.Name)
+		if _, ok := rmState.Template(m.Author.ID, name); !ok {
+			addReaction(s, m.ChannelID, m.ID, "❌")
+			return
+		}
+		rmState.SetTemplate(m.Author.ID, name, "")
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Pause:
+		if !rmState.Pause(m.Author.ID) {
+			since := rmState.Paused(m.Author.ID).In(rmState.Zone(m.Author.ID))
+			sendMsg(s, m.ChannelID, fmt.Sprintf("your reminders are already paused since %s",
+				since.Format(displayTimeFmt)))
+			return
+		}
+		sendMsg(s, m.ChannelID, fmt.Sprintf("paused your reminders; use `%s resume` to resume them", prefix))
+	case remindmeConfig.Resume:
+		resumed, due := rmState.Resume(m.Author.ID)
+		if resumed == -1 {
+			sendMsg(s, m.ChannelID, "your reminders are not paused")
+			return
+		}
+		reply := fmt.Sprintf("resumed %d reminders", resumed)
+		if due > 0 {
+			reply += fmt.Sprintf(" (%d came due while paused and go off now)", due)
+		}
+		sendMsg(s, m.ChannelID, reply)
+	case remindmeConfig.Preview:
+		now := rmState.clock.Now().In(rmState.Zone(m.Author.ID))
+		expiration, n, err := parseWhen(remindmeConfig.When, now)
+		if err == nil && n != len(remindmeConfig.When) {
+			err = fmt.Errorf("unexpected %q", strings.Join(remindmeConfig.When[n:], " "))
+		}
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		sendMsg(s, m.ChannelID, fmt.Sprintf("that would go off at %s, %s",
+			expiration.Format(displayTimeFmt), formatUntil(expiration.Sub(now))))
+	case remindmeConfig.Timezone:
+		// "Local" would be the bot's zone, which means nothing to users.
+		if remindmeConfig.Zone == "Local" {
+			parser.HelpHandler(fmt.Errorf("unknown time zone Local"), usage)
+			return
+		}
+		loc, err := time.LoadLocation(remindmeConfig.Zone)
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		rmState.SetZone(m.Author.ID, loc)
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Deliver:
+		if remindmeConfig.DM {
+			rmState.SetDeliveryChannel(m.Author.ID, "")
+			addReaction(s, m.ChannelID, m.ID, "✅")
+			return
+		}
+		if m.GuildID == "" {
+			sendMsg(s, m.ChannelID, "reminders can only be delivered in a server channel")
+			return
+		}
+		rmState.SetDeliveryChannel(m.Author.ID, m.ChannelID)
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Prefix:
+		if m.GuildID == "" {
+			sendMsg(s, m.ChannelID, "prefixes can only be set in a server")
+			return
+		}
+		perms, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+		if err != nil {
+			logger.User(m.Author.ID).Errorf("unable to get permissions of %s for prefix command: %v",
+				(*userLog)(m.Author), err)
+			sendMsgCmplx(s, m.ChannelID, internalErrMsg)
+			return
+		}
+		if perms&discordgo.PermissionManageServer == 0 {
+			sendMsg(s, m.ChannelID, "you need the Manage Server permission to set the prefix")
+			return
+		}
+		newPrefix := remindmeConfig.NewPrefix
+		if utf8.RuneCountInString(newPrefix) > maxPrefixLen {
+			parser.HelpHandler(fmt.Errorf("prefix must be at most %d characters", maxPrefixLen), usage)
+			return
+		}
+		rmState.SetPrefix(m.GuildID, newPrefix)
+		addReaction(s, m.ChannelID, m.ID, "✅")
+	case remindmeConfig.Batch:
+		// The items were split into words like any other arguments, so
+		// split the command again by its quotes instead.
+		rest := strings.TrimLeftFunc(strings.TrimPrefix(m.Content, argv[0]), unicode.IsSpace)
+		items, err := splitItems(strings.TrimPrefix(rest, "batch"))
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		now := rmState.clock.Now().In(rmState.Zone(m.Author.ID))
+		report := make([]string, len(items))
+		set := 0
+		for k, item := range items {
+			expiration, words, tags, err := parseNewReminder(strings.Fields(item), now)
+			var r *reminder
+			if err == nil {
+				r, err = setReminder(m.Author, m.Author, &reminder{
+					expiration: expiration,
+					message:    strings.Join(words, " "),
+					tags:       tags,
+				})
+			}
+			if err != nil {
+				report[k] = fmt.Sprintf("❌ %q: %v", item, err)
+				continue
+			}
+			set++
+			report[k] = fmt.Sprintf("✅ `%s` at %s: %s", r.id,
+				r.expiration.In(now.Location()).Format(displayTimeFmt), formatMessage(r))
+		}
+		sendMsg(s, m.ChannelID, fmt.Sprintf("set %d of %d reminders\n%s",
+			set, len(items), strings.Join(report, "\n")))
+	case remindmeConfig.Broadcast:
+		if ownerID == "" || m.Author.ID != ownerID {
+			sendMsg(s, m.ChannelID, "only the bot's owner may broadcast")
+			return
+		}
+		content := strings.Join(remindmeConfig.Message, " ")
+		// A broadcast counts as a delivery, so that shutting down waits
+		// for it to stop and report before closing the session.
+		if !rmState.startDelivery() {
+			sendMsg(s, m.ChannelID, "shutting down, not broadcasting")
+			return
+		}
+		logger.User(m.Author.ID).Infof("Broadcasting %q for %s", content, (*userLog)(m.Author))
+		addReaction(s, m.ChannelID, m.ID, "🆗")
+		go func() {
+			defer rmState.deliveries.Done()
+			sent, users := broadcast(s, content)
+			logger.Infof("Broadcast to %d of %d users", sent, users)
+			sendMsg(s, m.ChannelID, fmt.Sprintf("broadcast to %d of %d users", sent, users))
+		}()
+	case remindmeConfig.Snooze:
+		duration, err := parseReminderDuration(remindmeConfig.Duration)
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		id := strings.ToLower(remindmeConfig.ID)
+		if rmState.Snooze(m.Author.ID, id, duration) {
+			addReaction(s, m.ChannelID, m.ID, "✅")
+		} else {
+			addReaction(s, m.ChannelID, m.ID, "❌")
+		}
+	case remindmeConfig.SnoozeSoon:
+		duration, err := parseReminderDuration(remindmeConfig.Duration)
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		snoozed, firing := rmState.SnoozeSoon(m.Author.ID, duration)
+		reply := fmt.Sprintf("snoozed %d reminders by %s", snoozed, humanizeDuration(duration))
+		if firing > 0 {
+			reply += fmt.Sprintf(" (%d already going off)", firing)
+		}
+		sendMsg(s, m.ChannelID, reply)
+	case remindmeConfig.Repeat:
+		duration, err := parseReminderDuration(remindmeConfig.Duration)
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		id := strings.ToLower(remindmeConfig.ID)
+		source, ok := rmState.Source(m.Author.ID, id)
+		if !ok {
+			addReaction(s, m.ChannelID, m.ID, "❌")
+			return
+		}
+		// The copy goes off once, wherever the source would.
+		r, err := setReminder(m.Author, m.Author, &reminder{
+			expiration:  rmState.clock.Now().Add(duration),
+			message:     source.message,
+			channelID:   source.channelID,
+			tags:        source.tags,
+			quote:       source.quote,
+			label:       source.label,
+			attachments: source.attachments,
+		})
+		if err != nil {
+			sendMsg(s, m.ChannelID, err.Error())
+			return
+		}
+		addReaction(s, m.ChannelID, m.ID, "✅")
+		sendMsg(s, m.ChannelID, fmt.Sprintf("repeating `%s` as `%s` at %s", id, r.id,
+			r.expiration.In(rmState.Zone(m.Author.ID)).Format(displayTimeFmt)))
+	case remindmeConfig.AfterCmd:
+		duration, err := parseReminderDuration(remindmeConfig.Duration)
+		if err != nil {
+			parser.HelpHandler(err, usage)
+			return
+		}
+		words, tags := parseTags(remindmeConfig.Message)
+		if blankMessage(words) {
+			parser.HelpHandler(fmt.Errorf("missing message"), usage)
+			return
+		}
+		id := strings.ToLower(remindmeConfig.ID)
+		expiration, err := rmState.Expiration(m.Author.ID, id)
+		if err != nil {
+			cancelFailed(s, m, err)
+			return
+		}
+		var channelID string
+		if remindmeConfig.Here {
+			channelID = m.ChannelID
+		}
+		message := strings.Join(words, " ")
+		r, err := setReminder(m.Author, m.Author, &reminder{
+			expiration: expiration.Add(duration),
+			message:    message,
+			channelID:  channelID,
+			tags:       tags,
+			after:      id,
+		})
+		if err != nil {
+			sendMsg(s, m.ChannelID, err.Error())
+			return
+		}
+		if !remindmeConfig.Silent {
+			addReaction(s, m.ChannelID, m.ID, "🆗")
+		}
+		if remindmeConfig.Confirm || confirmReminders && !remindmeConfig.Silent {
+			when := r.expiration.In(rmState.Zone(m.Author.ID)).Format(displayTimeFmt)
+			sendMsg(s, m.ChannelID, fmt.Sprintf("Okay, I'll remind you at %s, %s after `%s`: %s (`%s`)",
+				when, humanizeDuration(duration), id, message, r.id))
+		}
+	case remindmeConfig.Give:
+		var to *discordgo.User
+		for _, u := range m.Mentions {
+			if remindmeConfig.User == "<@"+u.ID+">" || remindmeConfig.User == "<@!"+u.ID+">" {
+				to = u
+				break
+			}
+		}
+		if to == nil {
+			parser.HelpHandler(fmt.Errorf("mention the user to give the reminder to"), usage)
+			return
+		}
+		if to.Bot {
+			sendMsg(s, m.ChannelID, "bots cannot be reminded")
+			return
+		}
+		id := strings.ToLower(remindmeConfig.ID)
+		switch err := rmState.Transfer(m.Author.ID, id, to.ID, maxReminders); err {
+		case nil:
+			addReaction(s, m.ChannelID, m.ID, "✅")
+		case errTooManyReminders:
+			sendMsg(s, m.ChannelID, fmt.Sprintf("%s already has the maximum of %d reminders",
+				to.Username, maxReminders))
+		default:
+			cancelFailed(s, m, err)
+		}
+	case remindmeConfig.Edit:
+		if remindmeConfig.In == "" && len(remindmeConfig.Message) == 0 {
+			parser.HelpHandler(fmt.Errorf("nothing to edit"), usage)
+			return
+		}
+		var expiration time.Time
+		if remindmeConfig.In != "" {
+			duration, err := parseReminderDuration(remindmeConfig.In)
+			if err != nil {
+				parser.HelpHandler(err, usage)
+				return
+			}
+			expiration = rmState.now().Add(duration)
+		}
+		if len(remindmeConfig.Message) > 0 && blankMessage(remindmeConfig.Message) {
+			parser.HelpHandler(fmt.Errorf("missing message"), usage)
+			return
+		}
+		message := strings.Join(remindmeConfig.Message, " ")
+		err := checkMessageLen(message)
 		if err != nil {
-			parser.HelpHandler(err, remindmeUsage)
+			sendMsg(s, m.ChannelID, err.Error())
 			return
 		}
-		if rmState.Remove(m.Author.ID, expiration) {
+		id := strings.ToLower(remindmeConfig.ID)
+		if rmState.Edit(m.Author.ID, id, message, expiration) {
 			addReaction(s, m.ChannelID, m.ID, "✅")
 		} else {
 			addReaction(s, m.ChannelID, m.ID, "❌")
 		}
 	default:
 		author := m.Author
-		creation := time.Now().In(time.UTC)
-		duration, err := parseDuration(remindmeConfig.Duration)
+		if target == nil {
+			target = author
+		}
+		// <duration> may also be the start of a time spanning several words.
+		words := append([]string{remindmeConfig.Duration}, remindmeConfig.Message...)
+		now := rmState.clock.Now().In(rmState.Zone(author.ID))
+		var expiration time.Time
+		var daily string
+		var after time.Duration
+		var tags []string
+		if remindmeConfig.Daily {
+			// The reminder goes off at the time of day in the timezone of
+			// whoever it is for, when it comes to rescheduling it.
+			hour, min, ok := parseClock(remindmeConfig.Time)
+			if !ok {
+				err = fmt.Errorf("invalid time of day %q; write it like 8am, 8:30pm or 20:30",
+					remindmeConfig.Time)
+			} else {
+				daily = fmt.Sprintf("%02d:%02d", hour, min)
+				expiration, _ = nextDaily(daily, rmState.clock.Now().In(rmState.Zone(target.ID)))
+				words, tags = parseTags(remindmeConfig.Message)
+				if blankMessage(words) {
+					err = fmt.Errorf("missing message")
+				}
+			}
+		} else if remindmeConfig.AfterReply {
+			// Until the reply, there is only a duration to go off after.
+			after, err = parseReminderDuration(remindmeConfig.Duration)
+			if err == nil {
+				words, tags = parseTags(remindmeConfig.Message)
+				if blankMessage(words) {
+					err = fmt.Errorf("missing message")
+				}
+			}
+		} else {
+			expiration, words, tags, err = parseNewReminder(words, now)
+		}
+		var warn time.Duration
+		if err == nil && remindmeConfig.Warn != "" {
+			warn, err = parseDuration(remindmeConfig.Warn)
+			switch {
+			case err != nil:
+			case warn <= 0:
+				err = fmt.Errorf("--warn must be positive")
+			case daily != "" && warn >= day:
+				err = fmt.Errorf("--warn must be less than a day for a daily reminder")
+			case after > 0:
+				if warn >= after {
+					err = fmt.Errorf("--warn must be less than <duration>")
+				}
+			case daily == "" && !expiration.After(rmState.clock.Now().Add(warn)):
+				// A warning in the past would never be sent.
+				err = fmt.Errorf("--warn must be less than the time until the reminder goes off")
+			}
+		}
+		var priority int
+		if err == nil {
+			priority, err = parsePriority(remindmeConfig.Priority)
+		}
+		var label string
+		if err == nil && remindmeConfig.Label != "" {
+			label, err = parseLabel(remindmeConfig.Label)
+		}
+		if err == nil && len(remindmeConfig.Attach) > maxAttachments {
+			err = fmt.Errorf("a reminder may have at most %d attachments", maxAttachments)
+		}
+		for _, u := range remindmeConfig.Attach {
+			if err == nil {
+				err = checkAttachment(u)
+			}
+		}
 		if err != nil {
-			parser.HelpHandler(err, remindmeUsage)
+			parser.HelpHandler(err, usage)
 			return
 		}
-		expiration := creation.Add(duration)
+		// The message was checked before adding the context, so that a
+		// reminder cannot be only a link.
 		if remindmeConfig.WithContext {
-			remindmeConfig.Message = append(remindmeConfig.Message,
+			words = append(words,
 				fmt.Sprintf("\nContext: https://discordapp.com/channels/%s/%s/%s",
 					m.GuildID, m.ChannelID, m.ID))
 		}
-		message := strings.Join(remindmeConfig.Message, " ")
+		var quote string
+		if remindmeConfig.Quote {
+			ref := m.MessageReference
+			if ref == nil || ref.MessageID == "" {
+				parser.HelpHandler(fmt.Errorf("--quote needs a reply to the message to quote"), usage)
+				return
+			}
+			guildID := ref.GuildID
+			if guildID == "" {
+				guildID = m.GuildID
+			}
+			if guildID == "" {
+				guildID = "@me"
+			}
+			channelID := ref.ChannelID
+			if channelID == "" {
+				channelID = m.ChannelID
+			}
+			quote = guildID + "/" + channelID + "/" + ref.MessageID
+		}
+		message := strings.Join(words, " ")
+		var channelID string
+		if remindmeConfig.Here {
+			channelID = m.ChannelID
+		}
 		r := &reminder{
-			userID:     author.ID,
-			creation:   creation,
-			expiration: expiration,
-			message:    message,
+			expiration:  expiration,
+			message:     message,
+			channelID:   channelID,
+			tags:        tags,
+			daily:       daily,
+			quote:       quote,
+			warn:        warn,
+			priority:    priority,
+			label:       label,
+			attachments: remindmeConfig.Attach,
+		}
+		if after > 0 {
+			r, err = setTrigger(author, target, m.ID, after, r)
+		} else {
+			r, err = setReminder(author, target, r)
+		}
+		if err != nil {
+			sendMsg(s, m.ChannelID, err.Error())
+			return
+		}
+		// By default, the reminder is acknowledged with a reaction, plus a
+		// reply if confirmReminders is set. --silent drops both, and
+		// --confirm asks for the reply regardless. Neither is affected by
+		// --withcontext or --here.
+		if !remindmeConfig.Silent {
+			addReaction(s, m.ChannelID, m.ID, "🆗")
+		}
+		if remindmeConfig.Confirm || confirmReminders && !remindmeConfig.Silent {
+			who := "you"
+			if target.ID != author.ID {
+				who = target.Username
+			}
+			when := "at " + expiration.In(rmState.Zone(author.ID)).Format(displayTimeFmt) +
+				", " + formatUntil(rmState.until(expiration))
+			if after > 0 {
+				when = humanizeDuration(after) + " after someone replies to this"
+			}
+			// The ID is what it takes to cancel or edit the reminder.
+			sendMsg(s, m.ChannelID, fmt.Sprintf("Okay, I'll remind %s %s: %s (`%s`)",
+				who, when, message, r.id))
 		}
-		rmState.Add(r)
-		logger.Printf("Set reminder for %s to go off %s with the message %q",
-			(*userLog)(m.Author), expiration, message)
-		addReaction(s, m.ChannelID, m.ID, "🆗")
 	}
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: remindme <botToken>")
+	// Config
+	cfg, err := loadConfig(os.Getenv("REMINDME_CONFIG"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to load config:", err)
 		os.Exit(1)
 	}
-	botToken := os.Args[1]
-
+	if len(os.Args) < 2 && cfg.TokenFile == "" {
+		fmt.Println("Usage: remindme <botToken>, or set REMINDME_TOKEN_FILE")
+		os.Exit(1)
+	}
+	var botToken string
+	if len(os.Args) > 1 {
+		botToken = os.Args[1]
+	}
+	cfg.apply()
 	// Logging
-	err := os.Mkdir(loggerDirname, 0700)
-	if err != nil && !os.IsExist(err) {
-		panic(fmt.Errorf("unable to create logger directory: %v", err))
+	// If the log file cannot be created, as when the log directory is not
+	// writable, the log goes to stderr instead.
+	logName := time.Now().In(time.UTC).Format(time.RFC3339)
+	var logFile *os.File
+	err = os.Mkdir(loggerDirname, 0700)
+	if os.IsExist(err) {
+		err = nil
+	}
+	if err == nil {
+		logFile, err = os.Create(filepath.Join(loggerDirname, logName))
+	}
+	var logOut io.Writer = os.Stderr
+	if err == nil {
+		logOut = logFile
+	}
+	if cfg.LogFormat == "json" {
+		logger = newJSONLogger(logOut)
+	} else {
+		logger = newTextLogger(log.New(logOut,
+			"", log.Ldate|log.Lmicroseconds|log.Lshortfile|log.LUTC))
 	}
-	logFile, err := os.Create(loggerDirname + time.Now().In(time.UTC).Format(time.RFC3339))
-	logger = log.New(logFile,
-		"", log.Ldate|log.Lmicroseconds|log.Lshortfile|log.LUTC)
 	if err != nil {
-		logger.Panic("creating logfile: ", err)
+		logger.Error("unable to create log file, logging to stderr: ", err)
+	} else {
+		defer func() {
+			err = logFile.Close()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "closing logfile: ", err)
+			}
+		}()
+	}
+	// REST API settings
+	if cfg.AdminToken == "" {
+		logger.Info("REMINDME_ADMIN_TOKEN is not set; administrative endpoints are disabled")
 	}
-	defer func() {
-		err = logFile.Close()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "closing logfile: ", err)
-		}
-	}()
 	// Signal handler
 	go func() {
 		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, os.Interrupt, os.Kill)
-		<-sigs
-		stop <- struct{}{}
-	}()
-	// Terminal
-	go func() {
-		fmt.Println("Say \"stop\" to quit.")
-		var echo string
-		for echo != "stop" {
-			fmt.Scanln(&echo)
+		signal.Notify(sigs, os.Interrupt, os.Kill, syscall.SIGHUP)
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case sig := <-sigs:
+				if sig != syscall.SIGHUP {
+					requestStop()
+					return
+				}
+				logger.Info("Reloading reminders.")
+				reloadRMState()
+			case <-stop:
+				return
+			}
 		}
-		stop <- struct{}{}
 	}()
+	// Terminal. Without one, as under systemd or in a container, stdin
+	// is not read at all. A pending read cannot be interrupted, so this
+	// goroutine only ends with the process if something else stops the bot.
+	if isTerminal(os.Stdin) {
+		go func() {
+			fmt.Println("Say \"stop\" to quit.")
+			var echo string
+			for echo != "stop" {
+				_, err := fmt.Scanln(&echo)
+				if err == io.EOF {
+					return
+				}
+			}
+			requestStop()
+		}()
+	} else {
+		logger.Info("Stdin is not a terminal; not reading commands from it.")
+	}
 	// REST API
+	server := &http.Server{Addr: cfg.HTTPAddr}
 	go func() {
-		http.HandleFunc("/", func(_ http.ResponseWriter, req *http.Request) {
+		http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+			if !authorized(req) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
 			ls := len("stop")
 			buf := make([]byte, ls)
 			n, _ := req.Body.Read(buf)
 			if n == ls && string(buf) == "stop" {
-				stop <- struct{}{}
+				requestStop()
 			}
 		})
-		logger.Panic(http.ListenAndServe(":6767", nil))
+		http.HandleFunc("/reminders", remindersHandler)
+		http.HandleFunc("/reminders.ics", remindersICSHandler)
+		http.HandleFunc("/healthz", healthzHandler)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			logger.Panic(err)
+		}
 	}()
 	// Bot session
+	if botToken == "" {
+		botToken, err = readToken()
+		if err != nil {
+			logger.Panic("unable to read bot token: ", err)
+		}
+	}
 	session, err := discordgo.New("Bot " + botToken)
 	if err != nil {
 		logger.Panic(err)
 	}
+	removeGatewayHandlers = registerGatewayHandlers(session)
 	err = session.Open()
 	if err != nil {
 		logger.Panic(err)
 	}
-	logger.Print("Session opened.")
+	gateway.set(true)
+	logger.Info("Session opened.")
 	defer func() {
-		err = session.Close()
+		// The token may have been reloaded since.
+		err = rmState.Session().Close()
 		if err != nil {
-			logger.Print(err)
+			logger.Error(err)
 		}
-		logger.Print("Session closed.")
+		logger.Info("Session closed.")
 	}()
 	// Construct remindmeState
 	err = constructRMState(session)
 	if err != nil {
-		logger.Print(err)
+		logger.Error(err)
 	}
 	defer deconstructRMState()
-	// Register handler
-	session.AddHandler(remindmeHandler)
+	// Housekeeping
+	pruneFiles(loggerDirname, func(name string) bool {
+		return name != logName
+	})
+	pruneFiles(remindersDirname, isSnapshotName)
+	go func() {
+		for range time.Tick(rateInterval) {
+			pruneRateLimits(rmState.now())
+		}
+	}()
+	go func() {
+		for range time.Tick(pendingRetryInterval) {
+			rmState.RetryPending()
+		}
+	}()
+	go func() {
+		for range time.Tick(compactInterval) {
+			err := rmState.Compact()
+			if err != nil {
+				logger.Error("unable to compact reminders journal: ", err)
+			}
+		}
+	}()
+	// Register handlers
+	addHandlers(session)
+	go handleTokenReloads()
+	err = registerCommands(session)
+	if err != nil {
+		logger.Error("unable to register application commands: ", err)
+	}
 
 	<-stop
+	// Let requests in flight finish and release the port before the rest
+	// shuts down.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		logger.Error("unable to shut down HTTP server: ", err)
+	}
 }
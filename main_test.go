@@ -0,0 +1,1886 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMain(m *testing.M) {
+	logger = newTextLogger(log.New(ioutil.Discard, "", 0))
+	os.Exit(m.Run())
+}
+
+// newTestState returns an empty state keeping time by c, with neither
+// journal nor database.
+func newTestState(c Clock) *remindmeState {
+	return &remindmeState{
+		done:  make(chan struct{}),
+		clock: c,
+		Mutex: new(sync.Mutex),
+	}
+}
+
+// checkOrder fails t unless rs holds the reminders with the given ids in
+// that order, each with its own timer, and byUser agrees with them.
+func checkOrder(t *testing.T, rs *remindmeState, ids []string) {
+	t.Helper()
+	if len(rs.timers) != len(rs.reminders) {
+		t.Fatalf("%d timers for %d reminders", len(rs.timers), len(rs.reminders))
+	}
+	var got []string
+	for _, r := range rs.reminders {
+		got = append(got, r.id)
+	}
+	if strings.Join(got, " ") != strings.Join(ids, " ") {
+		t.Fatalf("reminders in order %v, want %v", got, ids)
+	}
+	n := 0
+	for userID, mine := range rs.byUser {
+		i, j := rs.userRange(userID)
+		if len(mine) != j-i {
+			t.Fatalf("byUser has %d reminders for %s, want %d", len(mine), userID, j-i)
+		}
+		for k, r := range mine {
+			if r != rs.reminders[i+k] {
+				t.Fatalf("byUser[%s][%d] is %s, want %s", userID, k, r.id, rs.reminders[i+k].id)
+			}
+		}
+		n += len(mine)
+	}
+	if n != len(rs.reminders) {
+		t.Fatalf("byUser has %d reminders, want %d", n, len(rs.reminders))
+	}
+	if len(rs.byID) != len(rs.reminders) {
+		t.Fatalf("byID has %d reminders, want %d", len(rs.byID), len(rs.reminders))
+	}
+	for k, r := range rs.reminders {
+		if rs.byID[r.id] != r {
+			t.Fatalf("byID[%s] is not the reminder stored with that id", r.id)
+		}
+		if got := rs.indexByID(r.id); got != k {
+			t.Fatalf("indexByID(%s) = %d, want %d", r.id, got, k)
+		}
+	}
+}
+
+// testReminder returns a reminder for userID with the given id, created
+// and going off the given offsets after fakeEpoch.
+func testReminder(userID, id string, creation, expiration time.Duration) *reminder {
+	return &reminder{
+		id:         id,
+		userID:     userID,
+		authorID:   userID,
+		creation:   fakeEpoch.Add(creation),
+		expiration: fakeEpoch.Add(expiration),
+		message:    "message " + id,
+	}
+}
+
+func TestAddOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []*reminder
+		want []string
+	}{{
+		name: "by user",
+		add: []*reminder{
+			testReminder("b", "b1", 0, time.Minute),
+			testReminder("a", "a1", 0, time.Hour),
+			testReminder("c", "c1", 0, time.Second),
+		},
+		want: []string{"a1", "b1", "c1"},
+	}, {
+		name: "by expiration",
+		add: []*reminder{
+			testReminder("u", "r3", 0, 3*time.Hour),
+			testReminder("u", "r1", 0, time.Hour),
+			testReminder("u", "r2", 0, 2*time.Hour),
+		},
+		want: []string{"r1", "r2", "r3"},
+	}, {
+		name: "tied expiration by creation",
+		add: []*reminder{
+			testReminder("u", "r2", 2*time.Second, time.Hour),
+			testReminder("u", "r3", 3*time.Second, time.Hour),
+			testReminder("u", "r1", time.Second, time.Hour),
+		},
+		want: []string{"r1", "r2", "r3"},
+	}, {
+		name: "tied expiration and creation by id",
+		add: []*reminder{
+			testReminder("u", "bbb", 0, time.Hour),
+			testReminder("u", "ccc", 0, time.Hour),
+			testReminder("u", "aaa", 0, time.Hour),
+		},
+		want: []string{"aaa", "bbb", "ccc"},
+	}, {
+		name: "mixed",
+		add: []*reminder{
+			testReminder("v", "v2", 0, 2*time.Hour),
+			testReminder("u", "u3", time.Second, time.Hour),
+			testReminder("v", "v1", 0, time.Hour),
+			testReminder("u", "u1", 0, time.Minute),
+			testReminder("u", "u2", 0, time.Hour),
+		},
+		want: []string{"u1", "u2", "u3", "v1", "v2"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			rs := newTestState(c)
+			for _, r := range test.add {
+				err := rs.Add(r, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rs.timers) != len(rs.reminders) {
+					t.Fatalf("%d timers for %d reminders", len(rs.timers), len(rs.reminders))
+				}
+			}
+			checkOrder(t, rs, test.want)
+			if n := c.active(); n != len(test.want) {
+				t.Errorf("%d timers active, want %d", n, len(test.want))
+			}
+		})
+	}
+}
+
+func TestRemoveOrder(t *testing.T) {
+	add := func() []*reminder {
+		return []*reminder{
+			testReminder("u", "u1", 0, time.Hour),
+			testReminder("u", "u2", time.Second, time.Hour),
+			testReminder("u", "u3", 0, 2*time.Hour),
+			testReminder("v", "v1", 0, time.Minute),
+			testReminder("v", "v2", 0, time.Hour),
+		}
+	}
+	tests := []struct {
+		name   string
+		remove [][2]string
+		want   []string
+	}{{
+		name:   "first",
+		remove: [][2]string{{"u", "u1"}},
+		want:   []string{"u2", "u3", "v1", "v2"},
+	}, {
+		name:   "middle",
+		remove: [][2]string{{"u", "u3"}},
+		want:   []string{"u1", "u2", "v1", "v2"},
+	}, {
+		name:   "last",
+		remove: [][2]string{{"v", "v2"}},
+		want:   []string{"u1", "u2", "u3", "v1"},
+	}, {
+		name:   "both ends",
+		remove: [][2]string{{"u", "u1"}, {"v", "v2"}},
+		want:   []string{"u2", "u3", "v1"},
+	}, {
+		name:   "a whole user",
+		remove: [][2]string{{"v", "v1"}, {"v", "v2"}},
+		want:   []string{"u1", "u2", "u3"},
+	}, {
+		name:   "everything",
+		remove: [][2]string{{"u", "u2"}, {"v", "v1"}, {"u", "u1"}, {"v", "v2"}, {"u", "u3"}},
+		want:   nil,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			rs := newTestState(c)
+			for _, r := range add() {
+				rs.Add(r, 0)
+			}
+			for _, remove := range test.remove {
+				err := rs.Remove(remove[0], remove[1])
+				if err != nil {
+					t.Fatalf("Remove(%s, %s): %v", remove[0], remove[1], err)
+				}
+				if len(rs.timers) != len(rs.reminders) {
+					t.Fatalf("%d timers for %d reminders", len(rs.timers), len(rs.reminders))
+				}
+			}
+			checkOrder(t, rs, test.want)
+			if n := c.active(); n != len(test.want) {
+				t.Errorf("%d timers active, want %d", n, len(test.want))
+			}
+		})
+	}
+}
+
+func TestRemoveNotFound(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	rs.Add(testReminder("u", "u1", 0, time.Hour), 0)
+	for _, remove := range [][2]string{{"u", "nope"}, {"v", "u1"}} {
+		if err := rs.Remove(remove[0], remove[1]); err != errNotFound {
+			t.Errorf("Remove(%s, %s) = %v, want %v", remove[0], remove[1], err, errNotFound)
+		}
+	}
+	checkOrder(t, rs, []string{"u1"})
+}
+
+func TestAddDedupWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		message string
+		offset  time.Duration
+		limit   int
+		want    error
+	}{
+		{"same time", "u", "message aaaaaa", 0, defaultMaxReminders, errDuplicate},
+		{"just inside after", "u", "message aaaaaa", dedupWindow - time.Nanosecond, defaultMaxReminders, errDuplicate},
+		{"just inside before", "u", "message aaaaaa", -dedupWindow + time.Nanosecond, defaultMaxReminders, errDuplicate},
+		{"at the window after", "u", "message aaaaaa", dedupWindow, defaultMaxReminders, nil},
+		{"at the window before", "u", "message aaaaaa", -dedupWindow, defaultMaxReminders, nil},
+		{"just outside after", "u", "message aaaaaa", dedupWindow + time.Nanosecond, defaultMaxReminders, nil},
+		{"other message", "u", "message bbbbbb", 0, defaultMaxReminders, nil},
+		{"other user", "v", "message aaaaaa", 0, defaultMaxReminders, nil},
+		{"loaded", "u", "message aaaaaa", 0, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := newTestState(newFakeClock())
+			addAllOrFail(t, rs, testReminder("u", "aaaaaa", 0, time.Hour))
+			r := testReminder(test.userID, "bbbbbb", 0, time.Hour+test.offset)
+			r.message = test.message
+			if err := rs.Add(r, test.limit); err != test.want {
+				t.Errorf("Add = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSetDuplicateReminder(t *testing.T) {
+	c, restore := useTestState(t)
+	defer restore()
+	const userID = "100000000000000001"
+	set := func() string {
+		sent := runCommand(t, userID, "!remindme 1h water the plants")
+		if len(sent) == 0 {
+			return ""
+		}
+		return sent[0].Content
+	}
+	set()
+	if len(rmState.reminders) != 1 {
+		t.Fatalf("%d reminders after setting one", len(rmState.reminders))
+	}
+	// Sent again just inside the window, the reminder would go off just
+	// under dedupWindow after the first.
+	c.Advance(dedupWindow - time.Millisecond)
+	if got := set(); got != "you already have that reminder" {
+		t.Errorf("setting it again inside the window replied %q", got)
+	}
+	c.Advance(time.Millisecond)
+	set()
+	if len(rmState.reminders) != 2 {
+		t.Errorf("%d reminders after setting it again outside the window, want 2", len(rmState.reminders))
+	}
+}
+
+func TestReadSnapshotVersions(t *testing.T) {
+	created := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	expires := time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		snapshot string
+		want     reminder
+	}{{
+		name:     "no header",
+		snapshot: "u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb\n",
+		want:     reminder{userID: "u", authorID: "u", creation: created, expiration: expires, message: "a\nb"},
+	}, {
+		name: "version 1",
+		snapshot: "snapshot,1\n" +
+			"u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb,id1,a,false\n" +
+			"end,1\n",
+		want: reminder{id: "id1", userID: "u", authorID: "a", creation: created, expiration: expires, message: "a\nb"},
+	}, {
+		name: "version 2",
+		snapshot: "snapshot,2\n" +
+			"u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,a\\nb,id1,a,false\n" +
+			"end,1\n",
+		want: reminder{id: "id1", userID: "u", authorID: "a", creation: created, expiration: expires, message: `a\nb`},
+	}, {
+		name: "version 3",
+		snapshot: "snapshot,3,user_id,creation,expiration,message,id,author_id,pending,label\n" +
+			"u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg,id1,a,true,lab\n" +
+			"end,1\n",
+		want: reminder{id: "id1", userID: "u", authorID: "a", creation: created, expiration: expires,
+			message: "msg", pending: true, label: "lab"},
+	}, {
+		name: "version 3 reordered with an unknown column",
+		snapshot: "snapshot,3,label,expiration,user_id,creation,message,future\n" +
+			"lab,2020-01-02T00:00:00Z,u,2020-01-01T00:00:00Z,msg,zzz\n" +
+			"end,1\n",
+		want: reminder{userID: "u", authorID: "u", creation: created, expiration: expires,
+			message: "msg", label: "lab"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reminders, err := readSnapshot(strings.NewReader(test.snapshot))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reminders) != 1 {
+				t.Fatalf("read %d reminders, want 1", len(reminders))
+			}
+			if got := reminders[0].String(); got != test.want.String() {
+				t.Errorf("read %s\nwant %s", got, test.want.String())
+			}
+		})
+	}
+}
+
+func TestReadSnapshotErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		err      string
+	}{{
+		name:     "missing column",
+		snapshot: "snapshot,3,user_id,creation\nend,0\n",
+		err:      "snapshot is missing the expiration column",
+	}, {
+		name:     "duplicate column",
+		snapshot: "snapshot,3,user_id,creation,expiration,message,user_id\nend,0\n",
+		err:      "duplicate snapshot column user_id",
+	}, {
+		name:     "unknown version",
+		snapshot: "snapshot,4\nend,0\n",
+		err:      "unknown snapshot header",
+	}, {
+		name:     "no trailer",
+		snapshot: "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg\n",
+		err:      "incomplete snapshot: no trailer",
+	}, {
+		name:     "wrong count",
+		snapshot: "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg\nend,2\n",
+		err:      "does not match 1 reminders",
+	}, {
+		name: "record shorter than the header",
+		snapshot: "snapshot,3,user_id,creation,expiration,message,id\n" +
+			"u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,msg\n" +
+			"end,1\n",
+		err: "invalid reminder record",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readSnapshot(strings.NewReader(test.snapshot))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "less than a minute"},
+		{30 * time.Second, "less than a minute"},
+		{time.Minute, "1 minute"},
+		{2 * time.Minute, "2 minutes"},
+		{time.Hour, "1 hour"},
+		{61 * time.Minute, "1 hour, 1 minute"},
+		{2*time.Hour + 30*time.Second, "2 hours"},
+		{day + time.Nanosecond, "1 day"},
+		{49 * time.Hour, "2 days, 1 hour"},
+		{3*day + 2*time.Hour + 5*time.Minute, "3 days, 2 hours, 5 minutes"},
+		{2*day + 7*time.Minute, "2 days, 7 minutes"},
+	}
+	for _, test := range tests {
+		if got := humanizeDuration(test.d); got != test.want {
+			t.Errorf("humanizeDuration(%v) = %q, want %q", test.d, got, test.want)
+		}
+	}
+}
+
+func TestFormatUntil(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Minute, "firing now"},
+		{0, "firing now"},
+		{10 * time.Second, "in less than a minute"},
+		{90 * time.Minute, "in 1 hour, 30 minutes"},
+	}
+	for _, test := range tests {
+		if got := formatUntil(test.d); got != test.want {
+			t.Errorf("formatUntil(%v) = %q, want %q", test.d, got, test.want)
+		}
+	}
+}
+
+// A fakeDiscord answers the requests of a discordgo.Session in place of
+// Discord, recording the messages sent and the responses to interactions.
+type fakeDiscord struct {
+	mu        sync.Mutex
+	sent      []sentMessage
+	responses []string
+}
+
+// A sentMessage is a message sent through a fakeDiscord.
+type sentMessage struct {
+	channelID string
+	discordgo.MessageSend
+}
+
+func (fd *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := strings.TrimPrefix(req.URL.Path, "/api/v"+discordgo.APIVersion)
+	body := "{}"
+	switch {
+	case req.Method == http.MethodPost && path == "/users/@me/channels":
+		body = `{"id":"300000000000000001","type":1}`
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/users/"):
+		body = `{"id":"` + strings.TrimPrefix(path, "/users/") + `","username":"user"}`
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/messages"):
+		channelID := strings.TrimSuffix(strings.TrimPrefix(path, "/channels/"), "/messages")
+		var msg sentMessage
+		msg.channelID = channelID
+		err := json.NewDecoder(req.Body).Decode(&msg.MessageSend)
+		if err != nil {
+			return nil, err
+		}
+		fd.mu.Lock()
+		fd.sent = append(fd.sent, msg)
+		body = fmt.Sprintf(`{"id":"%d","channel_id":"%s"}`, 400000000000000000+len(fd.sent), channelID)
+		fd.mu.Unlock()
+	case req.Method == http.MethodPost && strings.HasPrefix(path, "/interactions/"):
+		var resp discordgo.InteractionResponse
+		err := json.NewDecoder(req.Body).Decode(&resp)
+		if err != nil {
+			return nil, err
+		}
+		fd.mu.Lock()
+		fd.responses = append(fd.responses, resp.Data.Content)
+		fd.mu.Unlock()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newFakeSession returns a session talking to fd.
+func newFakeSession(fd *fakeDiscord) *discordgo.Session {
+	s, _ := discordgo.New("Bot test")
+	s.Client = &http.Client{Transport: fd}
+	return s
+}
+
+func TestDeliverMentions(t *testing.T) {
+	const userID = "100000000000000001"
+	message := "@everyone @here <@100000000000000009> <@!100000000000000009> <@&100000000000000008>"
+	tests := []struct {
+		name      string
+		channelID string
+		embed     bool
+		users     []string
+	}{
+		{"privately", "", false, nil},
+		{"in a channel", "200000000000000001", false, []string{userID}},
+		{"privately as an embed", "", true, nil},
+		{"in a channel as an embed", "200000000000000001", true, []string{userID}},
+	}
+	defer func(embed bool) { embedReminders = embed }(embedReminders)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			embedReminders = test.embed
+			fd := new(fakeDiscord)
+			rs := newTestState(newFakeClock())
+			rs.session = newFakeSession(fd)
+			r := testReminder(userID, "aaaaaa", 0, time.Hour)
+			r.authorID = userID
+			r.message = message
+			r.channelID = test.channelID
+			err := rs.deliver(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fd.sent) == 0 {
+				t.Fatal("nothing was sent")
+			}
+			for _, msg := range fd.sent {
+				content := msg.Content
+				for _, embed := range msg.Embeds {
+					content += embed.Description
+				}
+				if !strings.Contains(content, message) {
+					t.Errorf("sent %q, want it to contain the message", content)
+				}
+				mentions := msg.AllowedMentions
+				if mentions == nil || len(mentions.Parse) != 0 || len(mentions.Roles) != 0 {
+					t.Fatalf("sent with allowed mentions %+v, want none parsed", mentions)
+				}
+				if strings.Join(mentions.Users, " ") != strings.Join(test.users, " ") {
+					t.Errorf("sent allowing users %v to be mentioned, want %v", mentions.Users, test.users)
+				}
+			}
+		})
+	}
+}
+
+// listedIDs returns the ids of the reminders listed in pages, in order.
+func listedIDs(pages []string) []string {
+	var ids []string
+	for _, page := range pages {
+		for _, row := range strings.Split(page, "\n")[1:] {
+			if strings.HasPrefix(row, "`") {
+				ids = append(ids, strings.SplitN(row, "`", 3)[1])
+			}
+		}
+	}
+	return ids
+}
+
+func TestFormatRemindersOrder(t *testing.T) {
+	at := func(id string, expiration time.Duration) reminder {
+		return *testReminder("u", id, 0, expiration)
+	}
+	tests := []struct {
+		name      string
+		reminders []reminder
+		want      []string
+	}{{
+		name:      "by expiration",
+		reminders: []reminder{at("c", 3*time.Hour), at("a", time.Hour), at("b", 2*time.Hour)},
+		want:      []string{"a", "b", "c"},
+	}, {
+		name:      "equal expirations keep their order",
+		reminders: []reminder{at("z", time.Hour), at("m", time.Hour), at("a", time.Hour)},
+		want:      []string{"z", "m", "a"},
+	}, {
+		name: "equal expirations among others",
+		reminders: []reminder{at("late", 2*time.Hour), at("y", time.Hour), at("early", time.Minute),
+			at("x", time.Hour)},
+		want: []string{"early", "y", "x", "late"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := listedIDs(formatReminders(test.reminders, time.UTC, false))
+			if strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("listed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	const header = "`id` `message`\n"
+	var rows []string
+	for n := 0; n < 40; n++ {
+		// Rows of varying lengths, with multibyte runes, that fill a
+		// page at a different point each time.
+		rows = append(rows, fmt.Sprintf("`%02d` %s\n", n, strings.Repeat("é", 50+37*(n%9))))
+	}
+	long := "`xx` " + strings.Repeat("a", 3000) + "\n"
+	rows = append(rows, long, "`zz` last\n")
+	pages := paginate(header, rows)
+	if len(pages) < 3 {
+		t.Fatalf("got %d pages, want at least 3", len(pages))
+	}
+	var got []string
+	for n, page := range pages {
+		if l := utf8.RuneCountInString(page); l > maxMessageLen {
+			t.Errorf("page %d is %d characters long", n+1, l)
+		}
+		if !strings.HasPrefix(page, header) {
+			t.Errorf("page %d does not start with the header", n+1)
+		}
+		got = append(got, strings.SplitAfter(strings.TrimPrefix(page, header), "\n")...)
+		got = got[:len(got)-1] // after the final newline
+		if n < len(pages)-1 {
+			// The page was ended because the next row did not fit.
+			next := pages[n+1][len(header):]
+			next = next[:strings.Index(next, "\n")+1]
+			if l := utf8.RuneCountInString(page) + utf8.RuneCountInString(next); l <= maxMessageLen {
+				t.Errorf("page %d ended though the next row fit, at %d characters", n+1, l)
+			}
+		}
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("pages hold %d rows, want %d", len(got), len(rows))
+	}
+	for k, row := range got {
+		want := rows[k]
+		if want == long {
+			want = truncate(long, maxMessageLen-len(header)-1) + "\n"
+		}
+		if row != want {
+			t.Errorf("row %d is %.20q…, want %.20q…", k, row, want)
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	var want []string
+	for n := 0; n < 30; n++ {
+		r := testReminder("u", fmt.Sprintf("u%02d", n), 0, time.Duration(n+1)*time.Hour)
+		r.message = strings.Repeat("long message ", 15)
+		rmState.Add(r, 0)
+		want = append(want, r.id)
+	}
+	pages := listReminders("u", "", time.UTC)
+	if len(pages) < 3 {
+		t.Fatalf("got %d pages, want at least 3", len(pages))
+	}
+	for n, page := range pages {
+		if l := utf8.RuneCountInString(page); l > maxMessageLen {
+			t.Errorf("page %d is %d characters long", n+1, l)
+		}
+	}
+	if got := listedIDs(pages); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+}
+
+func TestRemoveMiddleOutOfOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    []time.Duration
+		remove string
+		want   []string
+	}{
+		{"middle by expiration", []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour}, "r3", []string{"r2", "r1"}},
+		{"middle added", []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour}, "r2", []string{"r3", "r1"}},
+		{"earliest added last", []time.Duration{2 * time.Hour, 3 * time.Hour, time.Hour}, "r1", []string{"r3", "r2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			rs := newTestState(c)
+			for n, d := range test.add {
+				rs.Add(testReminder("u", fmt.Sprintf("r%d", n+1), 0, d), 0)
+			}
+			err := rs.Remove("u", test.remove)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOrder(t, rs, test.want)
+			// Each remaining reminder keeps its own timer, and the removed
+			// one's is stopped.
+			for k, r := range rs.reminders {
+				if when := rs.timers[k].(*fakeTimer).when; !when.Equal(r.expiration) {
+					t.Errorf("reminder %s going off %v has the timer for %v", r.id, r.expiration, when)
+				}
+			}
+			if n := c.active(); n != len(test.want) {
+				t.Errorf("%d timers active, want %d", n, len(test.want))
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []int
+	}{
+		{"short", "hello", []int{5}},
+		{"exactly the limit", strings.Repeat("a", maxMessageLen), []int{maxMessageLen}},
+		{"3000 characters", strings.Repeat("a", 3000), []int{maxMessageLen, 1000}},
+		{"3000 multibyte characters", strings.Repeat("é", 3000), []int{maxMessageLen, 1000}},
+		{"over twice the limit", strings.Repeat("a", 2*maxMessageLen+1), []int{maxMessageLen, maxMessageLen, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := splitMessage(test.s)
+			var got []int
+			for _, chunk := range chunks {
+				got = append(got, utf8.RuneCountInString(chunk))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("split into chunks of %v characters, want %v", got, test.want)
+			}
+			if strings.Join(chunks, "") != test.s {
+				t.Error("chunks do not add up to the message")
+			}
+		})
+	}
+}
+
+func TestSendChunksLongMessage(t *testing.T) {
+	fd := new(fakeDiscord)
+	content := strings.Repeat("a", 3000)
+	msg, err := sendChunks(newFakeSession(fd), "200000000000000001", content, noMentions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fd.sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(fd.sent))
+	}
+	if fd.sent[0].Content+fd.sent[1].Content != content {
+		t.Error("messages sent do not add up to the content")
+	}
+	if msg == nil || msg.ID != "400000000000000002" {
+		t.Errorf("returned message %+v, want the last one sent", msg)
+	}
+}
+
+func TestPrepareReminderLength(t *testing.T) {
+	user := &discordgo.User{ID: "100000000000000001"}
+	tests := []struct {
+		name    string
+		message string
+		wantErr bool
+	}{
+		{"at the limit", strings.Repeat("a", maxReminderLen), false},
+		{"multibyte at the limit", strings.Repeat("é", maxReminderLen), false},
+		{"3000 characters", strings.Repeat("a", 3000), true},
+		{"one over the limit", strings.Repeat("a", maxReminderLen+1), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := prepareReminder(user, user, &reminder{message: test.message})
+			if (err != nil) != test.wantErr {
+				t.Errorf("prepareReminder returned %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEditLength(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	const userID = "100000000000000001"
+	err := rmState.Add(testReminder(userID, "aaaaaa", 0, time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("é", maxReminderLen+1)
+	if rmState.Edit(userID, "aaaaaa", long, time.Time{}) {
+		t.Error("Edit accepted a message over the limit")
+	}
+	sent := runCommand(t, userID, "!remindme edit aaaaaa "+long)
+	want := prepareReminder(&discordgo.User{ID: userID}, &discordgo.User{ID: userID},
+		&reminder{message: long}).Error()
+	if len(sent) != 1 || sent[0].Content != want {
+		t.Errorf("editing with a message over the limit sent %+v, want %q", sent, want)
+	}
+	if msg := rmState.reminders[0].message; msg != "message aaaaaa" {
+		t.Errorf("message edited to %d characters", utf8.RuneCountInString(msg))
+	}
+	at := strings.Repeat("é", maxReminderLen)
+	if !rmState.Edit(userID, "aaaaaa", at, time.Time{}) {
+		t.Error("Edit refused a message at the limit")
+	}
+}
+
+func TestDeliverLongestMessage(t *testing.T) {
+	// The longest message allowed still goes out in one message, along
+	// with what the delivery format puts around it.
+	fd := new(fakeDiscord)
+	rs := newTestState(newFakeClock())
+	rs.session = newFakeSession(fd)
+	r := testReminder("100000000000000001", "aaaaaa", 0, time.Hour)
+	r.message = strings.Repeat("a", maxReminderLen)
+	r.channelID = "200000000000000001"
+	err := rs.deliver(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fd.sent) != 1 {
+		t.Errorf("sent %d messages, want 1", len(fd.sent))
+	}
+}
+
+func TestReadFromShuffled(t *testing.T) {
+	reminders := []*reminder{
+		testReminder("u", "u1", 0, time.Hour),
+		testReminder("u", "u2", time.Second, time.Hour),
+		testReminder("u", "u3", time.Second, time.Hour),
+		testReminder("u", "u4", 0, 2*time.Hour),
+		testReminder("v", "v1", 0, time.Minute),
+		testReminder("v", "v2", 0, time.Minute),
+		testReminder("w", "w1", 0, 0),
+	}
+	want := []string{"u1", "u2", "u3", "u4", "v1", "v2", "w1"}
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 20; n++ {
+		var lines []string
+		for _, r := range reminders {
+			bb := new(bytes.Buffer)
+			ww := csv.NewWriter(bb)
+			ww.Write(r.record())
+			ww.Flush()
+			lines = append(lines, bb.String())
+		}
+		rnd.Shuffle(len(lines), func(i, j int) {
+			lines[i], lines[j] = lines[j], lines[i]
+		})
+		snapshot := fmt.Sprintf("snapshot,3,%s\n%send,%d\n",
+			strings.Join(reminderColumns[:], ","), strings.Join(lines, ""), len(lines))
+		rs := newTestState(newFakeClock())
+		_, err := rs.ReadFrom(strings.NewReader(snapshot))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkOrder(t, rs, want)
+		journal := "add," + strings.Join(lines, "add,")
+		rs = newTestState(newFakeClock())
+		err = rs.replay(strings.NewReader(journal))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkOrder(t, rs, want)
+	}
+}
+
+func TestMatching(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	for n, message := range []string{"Pay the RENT", "call mum", "rent a car"} {
+		r := testReminder("u", fmt.Sprintf("u%d", n+1), 0, time.Duration(n+1)*time.Hour)
+		r.message = message
+		rs.Add(r, 0)
+	}
+	r := testReminder("v", "v1", 0, time.Hour)
+	r.message = "pay rent"
+	rs.Add(r, 0)
+	tests := []struct {
+		userID string
+		text   string
+		want   []string
+	}{
+		{"u", "dentist", nil},
+		{"u", "mum", []string{"u2"}},
+		{"u", "rent", []string{"u1", "u3"}},
+		{"u", "RENT", []string{"u1", "u3"}},
+		{"u", "pay", []string{"u1"}},
+		{"v", "rent", []string{"v1"}},
+		{"w", "rent", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, r := range rs.Matching(test.userID, test.text) {
+			got = append(got, r.id)
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("Matching(%s, %q) = %v, want %v", test.userID, test.text, got, test.want)
+		}
+	}
+}
+
+func TestIsSnapshotName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"reminders-2020-01-01T00:00:00Z.csv", true},
+		{"reminders-2020-01-01T00:00:00Z.shards", true},
+		{"reminders-2020-01-01T00:00:00Z.shards.tmp", false},
+		{"reminders-2020-01-01T00:00:00Z.csv.123456", false},
+		{"reminders.log", false},
+		{"timezones.csv", false},
+		{"triggers.csv", false},
+	}
+	for _, test := range tests {
+		if got := isSnapshotName(test.name); got != test.want {
+			t.Errorf("isSnapshotName(%q) = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+// useTestState makes rmState empty and keep time by a fake clock, with its
+// files in a temporary directory, until the returned function is called.
+func useTestState(t *testing.T) (c *fakeClock, restore func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "remindme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = newFakeClock()
+	oldDirname := remindersDirname
+	remindersDirname = dir
+	rmState.reset()
+	rmState.clock = c
+	// Every fake clock starts at fakeEpoch, so commands sent in earlier
+	// tests would still count against the rate limit.
+	clearRateLimits()
+	return c, func() {
+		clearRateLimits()
+		rmState.reset()
+		rmState.zones = nil
+		rmState.prefixes = nil
+		rmState.paused = nil
+		rmState.templates = nil
+		rmState.channels = nil
+		rmState.clock = realClock{}
+		remindersDirname = oldDirname
+		os.RemoveAll(dir)
+	}
+}
+
+// clearRateLimits forgets the commands every user has sent.
+func clearRateLimits() {
+	rateLimits.Range(func(userID, _ interface{}) bool {
+		rateLimits.Delete(userID)
+		return true
+	})
+}
+
+func TestImportRMSnapshotFallback(t *testing.T) {
+	const (
+		older = "reminders-2020-01-01T00:00:00Z"
+		newer = "reminders-2020-01-02T00:00:00Z"
+	)
+	good := "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,older,id1,u,false\nend,1\n"
+	goodNewer := "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,newer,id2,u,false\nend,1\n"
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{{
+		name:  "newest complete",
+		files: map[string]string{older + ".csv": good, newer + ".csv": goodNewer},
+		want:  "newer",
+	}, {
+		name:  "newest truncated",
+		files: map[string]string{older + ".csv": good, newer + ".csv": goodNewer[:len(goodNewer)-6]},
+		want:  "older",
+	}, {
+		name: "newest cut off mid-record",
+		files: map[string]string{older + ".csv": good,
+			newer + ".csv": "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,new"},
+		want: "older",
+	}, {
+		name:  "newest with the wrong count",
+		files: map[string]string{older + ".csv": good, newer + ".csv": strings.Replace(goodNewer, "end,1", "end,2", 1)},
+		want:  "older",
+	}, {
+		name:  "newest sharded missing shards",
+		files: map[string]string{older + ".csv": good, newer + ".shards/0.csv": "snapshot,2\nend,0\n"},
+		want:  "older",
+	}, {
+		name:    "none complete",
+		files:   map[string]string{older + ".csv": good[:20], newer + ".csv": goodNewer[:20]},
+		wantErr: true,
+	}, {
+		name:  "none at all",
+		files: map[string]string{"timezones.csv": ""},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, restore := useTestState(t)
+			defer restore()
+			for name, content := range test.files {
+				path := filepath.Join(remindersDirname, name)
+				os.MkdirAll(filepath.Dir(path), 0700)
+				err := ioutil.WriteFile(path, []byte(content), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := importRMSnapshot()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("importRMSnapshot returned %v, want error %t", err, test.wantErr)
+			}
+			var got string
+			for _, r := range rmState.reminders {
+				got += r.message
+			}
+			if got != test.want {
+				t.Errorf("imported %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		words string
+		rest  string
+		tags  string
+	}{
+		{"pay rent", "pay rent", ""},
+		{"pay rent #home #bills", "pay rent", "home bills"},
+		{"#home pay #bills rent", "pay rent", "home bills"},
+		{`call \#1 now`, "call #1 now", ""},
+		{`\#home is not a tag #real`, "#home is not a tag", "real"},
+		{"# alone #", "# alone #", ""},
+		{"#two#tags #with.dot #ok_1 #ok-2 #été", "#two#tags #with.dot", "ok_1 ok-2 été"},
+		{"email a#b", "email a#b", ""},
+	}
+	for _, test := range tests {
+		rest, tags := parseTags(strings.Fields(test.words))
+		if strings.Join(rest, " ") != test.rest || strings.Join(tags, " ") != test.tags {
+			t.Errorf("parseTags(%q) = %q, %q; want %q, %q", test.words, rest, tags, test.rest, test.tags)
+		}
+	}
+}
+
+func TestListRemindersByTag(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	for n, tags := range [][]string{{"home"}, {"work", "Bills"}, nil, {"bills"}} {
+		r := testReminder("u", fmt.Sprintf("u%d", n+1), 0, time.Duration(n+1)*time.Hour)
+		r.tags = tags
+		rmState.Add(r, 0)
+	}
+	r := testReminder("v", "v1", 0, time.Hour)
+	r.tags = []string{"home"}
+	rmState.Add(r, 0)
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"", []string{"u1", "u2", "u3", "u4"}},
+		{"home", []string{"u1"}},
+		{"bills", []string{"u2", "u4"}},
+		{"BILLS", []string{"u2", "u4"}},
+		{"bill", nil},
+		{"travel", nil},
+	}
+	for _, test := range tests {
+		got := listedIDs(listReminders("u", test.tag, time.UTC))
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("listReminders(u, %q) listed %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestListBetween(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	for n := 1; n <= 4; n++ {
+		rmState.Add(testReminder("u", fmt.Sprintf("u%d", n), 0, time.Duration(n)*time.Hour), 0)
+	}
+	rmState.Add(testReminder("v", "v2", 0, 2*time.Hour), 0)
+	at := func(hours int) time.Time {
+		return fakeEpoch.Add(time.Duration(hours) * time.Hour)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []string
+	}{
+		{"both ends included", at(2), at(3), []string{"u2", "u3"}},
+		{"a nanosecond inside", at(2).Add(time.Nanosecond), at(3).Add(-time.Nanosecond), nil},
+		{"one instant", at(2), at(2), []string{"u2"}},
+		{"open start", time.Time{}, at(3), []string{"u1", "u2", "u3"}},
+		{"open end", at(3), time.Time{}, []string{"u3", "u4"}},
+		{"open both", time.Time{}, time.Time{}, []string{"u1", "u2", "u3", "u4"}},
+		{"inverted", at(3), at(2), nil},
+		{"before all", at(-2), at(0), nil},
+	}
+	for _, test := range tests {
+		got := listedIDs(listBetween("u", test.start, test.end))
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: listed %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBetweenCommand(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	const userID = "100000000000000001"
+	for n := 1; n <= 3; n++ {
+		rmState.Add(testReminder(userID, fmt.Sprintf("u%d", n), 0, time.Duration(n)*time.Hour), 0)
+	}
+	contents := func(sent []sentMessage) []string {
+		var pages []string
+		for _, m := range sent {
+			pages = append(pages, m.Content)
+		}
+		return pages
+	}
+	sent := runCommand(t, userID, "!remindme between 2020-01-01T14:00:00Z and -")
+	if got := listedIDs(contents(sent)); strings.Join(got, " ") != "u2 u3" {
+		t.Errorf("listed %v, want [u2 u3]", got)
+	}
+	sent = runCommand(t, userID, "!remindme between 2020-01-01T16:00:00Z and -")
+	if len(sent) != 1 || sent[0].Content != "you have no reminders then" {
+		t.Errorf("an empty range sent %+v", contents(sent))
+	}
+	sent = runCommand(t, userID, "!remindme between 2020-01-01T15:00:00Z and 2020-01-01T14:00:00Z")
+	// The error comes first, followed by the usage.
+	if len(sent) == 0 || !strings.HasPrefix(sent[0].Content, "the start must be before the end") {
+		t.Errorf("an inverted range sent %+v", contents(sent))
+	}
+}
+
+// startFiring makes it look as though the timer of the reminder with the
+// given id has fired, so that it can no longer be changed.
+func startFiring(t *testing.T, rs *remindmeState, id string) {
+	t.Helper()
+	k := rs.indexByID(id)
+	if k == -1 || !rs.timers[k].Stop() {
+		t.Fatalf("reminder %s has no timer to fire", id)
+	}
+}
+
+// expirations returns how long after fakeEpoch each reminder of rs goes
+// off, by id, checking that its timer agrees.
+func expirations(t *testing.T, rs *remindmeState) map[string]time.Duration {
+	t.Helper()
+	got := make(map[string]time.Duration)
+	for k, r := range rs.reminders {
+		got[r.id] = r.expiration.Sub(fakeEpoch)
+		if ft := rs.timers[k].(*fakeTimer); !ft.stopped && !ft.when.Equal(r.expiration) {
+			t.Errorf("reminder %s going off %v has the timer for %v", r.id, r.expiration, ft.when)
+		}
+	}
+	return got
+}
+
+func TestShift(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"later", 90 * time.Minute},
+		{"earlier", -30 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := newTestState(newFakeClock())
+			pending := testReminder("u", "pppppp", 0, -time.Minute)
+			pending.pending = true
+			addAllOrFail(t, rs,
+				testReminder("u", "aaaaaa", 0, time.Hour),
+				testReminder("u", "bbbbbb", 0, 2*time.Hour),
+				testReminder("u", "cccccc", 0, 3*time.Hour),
+				pending,
+				testReminder("v", "vvvvvv", 0, time.Hour))
+			startFiring(t, rs, "bbbbbb")
+			startFiring(t, rs, "pppppp")
+			shifted, firing := rs.Shift("u", test.d)
+			if shifted != 2 || firing != 2 {
+				t.Errorf("Shift = %d, %d; want 2, 2", shifted, firing)
+			}
+			got := expirations(t, rs)
+			for id, want := range map[string]time.Duration{
+				"aaaaaa": time.Hour + test.d,
+				"bbbbbb": 2 * time.Hour,
+				"cccccc": 3*time.Hour + test.d,
+				"pppppp": -time.Minute,
+				"vvvvvv": time.Hour,
+			} {
+				if got[id] != want {
+					t.Errorf("reminder %s goes off after %v, want %v", id, got[id], want)
+				}
+			}
+			var want []string
+			if test.d > 0 {
+				want = []string{"pppppp", "bbbbbb", "aaaaaa", "cccccc", "vvvvvv"}
+			} else {
+				want = []string{"pppppp", "aaaaaa", "bbbbbb", "cccccc", "vvvvvv"}
+			}
+			checkOrder(t, rs, want)
+		})
+	}
+}
+
+func TestShiftCommand(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	defer func() {
+		rmState.Lock()
+		rmState.closeJournal()
+		rmState.Unlock()
+	}()
+	err := rmState.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const userID = "100000000000000001"
+	addAllOrFail(t, &rmState,
+		testReminder(userID, "aaaaaa", 0, time.Hour),
+		testReminder(userID, "bbbbbb", 0, 2*time.Hour))
+	sent := runCommand(t, userID, "!remindme shift -- -15m")
+	if len(sent) != 1 || sent[0].Content != "shifted 2 reminders" {
+		t.Errorf("shift sent %+v, want shifted 2 reminders", sent)
+	}
+	for _, offset := range []string{"0s", "3y", "soon"} {
+		runCommand(t, userID, "!remindme shift "+offset)
+	}
+	// The shift is journaled, and nothing else was shifted.
+	reloadRMState()
+	got := expirations(t, &rmState)
+	if got["aaaaaa"] != 45*time.Minute || got["bbbbbb"] != 105*time.Minute {
+		t.Errorf("reloaded reminders go off after %v, want 45m and 1h45m", got)
+	}
+}
+
+func TestSnoozeSoon(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	pending := testReminder("u", "pppppp", 0, -time.Minute)
+	pending.pending = true
+	addAllOrFail(t, rs,
+		pending,
+		testReminder("u", "ffffff", 0, 10*time.Minute),
+		testReminder("u", "aaaaaa", 0, 30*time.Minute),
+		dependent("u", "eeeeee", 45*time.Minute, "aaaaaa"),
+		testReminder("u", "bbbbbb", 0, snoozeSoonWindow),
+		testReminder("u", "cccccc", 0, snoozeSoonWindow+time.Minute),
+		dependent("u", "dddddd", 2*time.Hour, "aaaaaa"),
+		testReminder("v", "vvvvvv", 0, 30*time.Minute))
+	startFiring(t, rs, "ffffff")
+	startFiring(t, rs, "pppppp")
+	snoozed, firing := rs.SnoozeSoon("u", time.Hour)
+	// The dependent going off soon counts as snoozed along with the one
+	// it depends on; the one going off later moves without counting.
+	if snoozed != 3 || firing != 2 {
+		t.Errorf("SnoozeSoon = %d, %d; want 3, 2", snoozed, firing)
+	}
+	got := expirations(t, rs)
+	for id, want := range map[string]time.Duration{
+		"pppppp": -time.Minute,
+		"ffffff": 10 * time.Minute,
+		"aaaaaa": 90 * time.Minute,
+		"eeeeee": 105 * time.Minute,
+		"bbbbbb": snoozeSoonWindow + time.Hour,
+		"cccccc": snoozeSoonWindow + time.Minute,
+		"dddddd": 3 * time.Hour,
+		"vvvvvv": 30 * time.Minute,
+	} {
+		if got[id] != want {
+			t.Errorf("reminder %s goes off after %v, want %v", id, got[id], want)
+		}
+	}
+	checkOrder(t, rs, []string{"pppppp", "ffffff", "cccccc", "aaaaaa", "eeeeee", "bbbbbb", "dddddd", "vvvvvv"})
+}
+
+func TestSnoozeSoonPaused(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		testReminder("u", "bbbbbb", 0, 5*time.Hour))
+	rs.Pause("u")
+	c.Advance(2 * time.Hour)
+	// The reminder that came due while paused is snoozed along with
+	// those going off soon, so that it does not go off on resume.
+	if snoozed, firing := rs.SnoozeSoon("u", 3*time.Hour); snoozed != 1 || firing != 0 {
+		t.Errorf("SnoozeSoon = %d, %d; want 1, 0", snoozed, firing)
+	}
+	if _, due := rs.Resume("u"); due != 0 {
+		t.Errorf("%d reminders due on resume, want none", due)
+	}
+	got := expirations(t, rs)
+	if got["aaaaaa"] != 4*time.Hour || got["bbbbbb"] != 5*time.Hour {
+		t.Errorf("reminders go off after %v, want 4h and 5h", got)
+	}
+}
+
+func TestSnoozeSoonCommand(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	const userID = "100000000000000001"
+	addAllOrFail(t, &rmState,
+		testReminder(userID, "aaaaaa", 0, 30*time.Minute),
+		testReminder(userID, "bbbbbb", 0, 40*time.Minute),
+		testReminder(userID, "cccccc", 0, 2*time.Hour))
+	startFiring(t, &rmState, "bbbbbb")
+	sent := runCommand(t, userID, "!remindme snooze-soon 2h")
+	want := "snoozed 1 reminders by " + humanizeDuration(2*time.Hour) + " (1 already going off)"
+	if len(sent) != 1 || sent[0].Content != want {
+		t.Errorf("snooze-soon sent %+v, want %q", sent, want)
+	}
+	if got := expirations(t, &rmState)["aaaaaa"]; got != 150*time.Minute {
+		t.Errorf("snoozed reminder goes off after %v, want 2h30m", got)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	r := testReminder("u", "aaaaaa", 0, 2*time.Hour)
+	r.authorID = "w"
+	r.tags = []string{"home"}
+	r.label = "rent"
+	addAllOrFail(t, rs, r,
+		testReminder("u", "bbbbbb", 0, time.Hour),
+		testReminder("v", "vvvvvv", 0, 3*time.Hour))
+	err := rs.Transfer("u", "aaaaaa", "v", defaultMaxReminders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, rs, []string{"bbbbbb", "aaaaaa", "vvvvvv"})
+	got := rs.reminders[rs.indexByID("aaaaaa")]
+	want := *r
+	want.userID = "v"
+	if strings.Join(got.record(), ",") != strings.Join(want.record(), ",") {
+		t.Errorf("transferred %s, want %s", got.record(), want.record())
+	}
+	if got := expirations(t, rs)["aaaaaa"]; got != 2*time.Hour {
+		t.Errorf("transferred reminder goes off after %v, want 2h", got)
+	}
+	if rs.find("u", "aaaaaa") != -1 {
+		t.Error("reminder still found for u")
+	}
+}
+
+func TestTransferFails(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	sent := testReminder("v", "ssssss", 0, time.Hour)
+	sent.authorID = "u"
+	addAllOrFail(t, rs,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		testReminder("u", "ffffff", 0, 2*time.Hour),
+		sent,
+		testReminder("w", "wwwwww", 0, time.Hour))
+	startFiring(t, rs, "ffffff")
+	tests := []struct {
+		name   string
+		id, to string
+		limit  int
+		want   error
+	}{
+		{"no such reminder", "zzzzzz", "v", defaultMaxReminders, errNotFound},
+		{"set for another user", "ssssss", "w", defaultMaxReminders, errNotFound},
+		{"recipient at the limit", "aaaaaa", "w", 1, errTooManyReminders},
+		{"already going off", "ffffff", "v", defaultMaxReminders, errFiring},
+	}
+	for _, test := range tests {
+		if err := rs.Transfer("u", test.id, test.to, test.limit); err != test.want {
+			t.Errorf("%s: Transfer = %v, want %v", test.name, err, test.want)
+		}
+	}
+	checkOrder(t, rs, []string{"aaaaaa", "ffffff", "ssssss", "wwwwww"})
+	if rs.reminders[0].userID != "u" {
+		t.Error("failed transfer moved the reminder")
+	}
+}
+
+func TestGiveCommand(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	defer func() {
+		rmState.Lock()
+		rmState.closeJournal()
+		rmState.Unlock()
+	}()
+	err := rmState.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const userID, to = "100000000000000001", "100000000000000002"
+	addAllOrFail(t, &rmState,
+		testReminder(userID, "aaaaaa", 0, time.Hour),
+		testReminder(to, "bbbbbb", 0, time.Hour))
+	give := func(mention *discordgo.User) []sentMessage {
+		fd := new(fakeDiscord)
+		s := newFakeSession(fd)
+		s.State.User = &discordgo.User{ID: "100000000000000000", Bot: true}
+		rmState.session = s
+		remindmeHandler(s, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        "500000000000000001",
+			ChannelID: "200000000000000001",
+			GuildID:   "600000000000000001",
+			Content:   "!remindme give aaaaaa <@" + mention.ID + ">",
+			Author:    &discordgo.User{ID: userID, Username: "user"},
+			Mentions:  []*discordgo.User{mention},
+		}})
+		return fd.sent
+	}
+	sent := give(&discordgo.User{ID: "100000000000000009", Bot: true})
+	if len(sent) != 1 || sent[0].Content != "bots cannot be reminded" {
+		t.Errorf("giving to a bot sent %+v", sent)
+	}
+	oldMax := maxReminders
+	maxReminders = 1
+	sent = give(&discordgo.User{ID: to, Username: "friend"})
+	maxReminders = oldMax
+	if want := "friend already has the maximum of 1 reminders"; len(sent) != 1 || sent[0].Content != want {
+		t.Errorf("giving to a user at the limit sent %+v, want %q", sent, want)
+	}
+	give(&discordgo.User{ID: to, Username: "friend"})
+	// The transfer is journaled.
+	reloadRMState()
+	checkOrder(t, &rmState, []string{"aaaaaa", "bbbbbb"})
+	if got := rmState.reminders[0].userID; got != to {
+		t.Errorf("reloaded reminder is for %s, want %s", got, to)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []*reminder
+		want string
+	}{{
+		name: "none",
+		want: "",
+	}, {
+		name: "created last, not going off last",
+		add: []*reminder{
+			testReminder("u", "old", 0, 3*time.Hour),
+			testReminder("u", "new", 2*time.Second, time.Hour),
+			testReminder("u", "mid", time.Second, 2*time.Hour),
+		},
+		want: "new",
+	}, {
+		name: "added first, created last",
+		add: []*reminder{
+			testReminder("u", "new", time.Minute, time.Hour),
+			testReminder("u", "old", 0, time.Hour),
+		},
+		want: "new",
+	}, {
+		name: "other users' newer reminders",
+		add: []*reminder{
+			testReminder("u", "mine", 0, time.Hour),
+			testReminder("v", "theirs", time.Hour, time.Hour),
+		},
+		want: "mine",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := newTestState(newFakeClock())
+			for _, r := range test.add {
+				rs.Add(r, 0)
+			}
+			if got := rs.Latest("u"); got != test.want {
+				t.Errorf("Latest = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBlankMessage(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  bool
+	}{
+		{nil, true},
+		{[]string{""}, true},
+		{[]string{"   "}, true},
+		{[]string{"\t", "\n"}, true},
+		{[]string{"\u200b"}, true},
+		{[]string{"\u00a0 "}, true},
+		{[]string{"\u200b\u2060\ufeff"}, true},
+		{[]string{"a"}, false},
+		{[]string{" ", "."}, false},
+		{[]string{"\u200bx"}, false},
+	}
+	for _, test := range tests {
+		if got := blankMessage(test.words); got != test.want {
+			t.Errorf("blankMessage(%q) = %t, want %t", test.words, got, test.want)
+		}
+	}
+}
+
+func TestParseNewReminderBlank(t *testing.T) {
+	tests := []struct {
+		words   string
+		wantErr bool
+	}{
+		{"1h pay rent", false},
+		{"1h \u200b", true},
+		{"1h #home", true},
+		{"1h #home \u200b #bills", true},
+		{"1h \\#home", false},
+	}
+	for _, test := range tests {
+		_, _, _, err := parseNewReminder(strings.Fields(test.words), fakeEpoch)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseNewReminder(%q) returned %v, want error %t", test.words, err, test.wantErr)
+		}
+	}
+}
+
+// runCommand handles content as a message sent by authorID in a guild
+// channel, with rmState as set up by useTestState, and returns the
+// messages the bot sent in reply.
+func runCommand(t *testing.T, authorID string, content string) []sentMessage {
+	t.Helper()
+	fd := new(fakeDiscord)
+	s := newFakeSession(fd)
+	s.State.User = &discordgo.User{ID: "100000000000000000", Bot: true}
+	rmState.session = s
+	remindmeHandler(s, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "500000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   "600000000000000001",
+		Content:   content,
+		Author:    &discordgo.User{ID: authorID, Username: "user"},
+	}})
+	return fd.sent
+}
+
+func TestSetBlankReminder(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	tests := []struct {
+		name    string
+		content string
+		message string
+	}{
+		{"zero-width space", "!remindme 1h \u200b", ""},
+		{"only tags", "!remindme 1h #home #bills", ""},
+		{"only context", "!remindme 1h -c \u200b", ""},
+		{"only context and tags", "!remindme 1h --withcontext #home", ""},
+		{"daily with only a zero-width space", "!remindme daily 9am \u200b", ""},
+		{"with context", "!remindme 1h -c pay rent",
+			"pay rent \nContext: https://discordapp.com/channels/600000000000000001/200000000000000001/500000000000000001"},
+	}
+	for n, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authorID := fmt.Sprintf("1000000000000001%02d", n)
+			sent := runCommand(t, authorID, test.content)
+			mine := rmState.byUser[authorID]
+			if test.message == "" {
+				if len(mine) != 0 {
+					t.Fatalf("set a reminder with the message %q", mine[0].message)
+				}
+				if len(sent) == 0 || !strings.HasPrefix(sent[0].Content, "missing message") {
+					t.Errorf("replied %+v, want missing message", sent)
+				}
+				return
+			}
+			if len(mine) != 1 {
+				t.Fatalf("set %d reminders, want 1", len(mine))
+			}
+			if mine[0].message != test.message {
+				t.Errorf("set a reminder with the message %q, want %q", mine[0].message, test.message)
+			}
+		})
+	}
+}
+
+func TestReadSnapshotMalformed(t *testing.T) {
+	header := "snapshot,3," + strings.Join(reminderColumns[:], ",") + "\n"
+	good := strings.Join(fullReminders()[2].record(), ",") + "\n"
+	var snapshots []string
+	for _, record := range badRecords {
+		if len(record) == 0 {
+			continue
+		}
+		bb := new(bytes.Buffer)
+		ww := csv.NewWriter(bb)
+		ww.Write(record)
+		ww.Flush()
+		snapshots = append(snapshots,
+			"snapshot,2\n"+bb.String()+good+"end,2\n",
+			bb.String()+good)
+	}
+	snapshots = append(snapshots,
+		"snapshot\n",
+		"snapshot,3\nend,0\n",
+		"snapshot,2,extra\nend,0\n",
+		"snapshot,-1\nend,0\n",
+		header+good+"end\n",
+		header+good+"end,one\n",
+		header+good+"end,1,2\n",
+		header+good+"end,-1\n",
+		header+good+"end,18446744073709551617\n",
+		header+"u\n"+"end,1\n",
+		header+good+strings.TrimSuffix(good, "\n")+",extra\nend,2\n",
+		`snapshot,2`+"\n"+`u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,"unterminated`+"\nend,1\n",
+		"snapshot,2\n"+`u,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,bad"quote`+"\nend,1\n",
+	)
+	for _, snapshot := range snapshots {
+		mustNotPanic(t, snapshot, func() {
+			if _, err := readSnapshot(strings.NewReader(snapshot)); err == nil {
+				t.Errorf("readSnapshot(%q) succeeded, want an error", snapshot)
+			}
+			c := newFakeClock()
+			rs := newTestState(c)
+			if _, err := rs.ReadFrom(strings.NewReader(snapshot)); err == nil {
+				t.Errorf("ReadFrom(%q) succeeded, want an error", snapshot)
+			}
+			if len(rs.reminders) != 0 || c.active() != 0 {
+				t.Errorf("ReadFrom(%q) failed leaving %d reminders and %d timers",
+					snapshot, len(rs.reminders), c.active())
+			}
+		})
+	}
+}
+
+func TestReadSnapshotTruncated(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	for _, r := range fullReminders() {
+		rs.Add(r, 0)
+	}
+	bb := new(bytes.Buffer)
+	rs.WriteTo(bb)
+	snapshot := bb.String()
+	// Cut off anywhere but after the final line break, it is incomplete.
+	for n := 0; n < len(snapshot)-1; n++ {
+		mustNotPanic(t, snapshot[:n], func() {
+			if _, err := readSnapshot(strings.NewReader(snapshot[:n])); err == nil && n > 0 {
+				t.Errorf("readSnapshot of the first %d bytes succeeded, want an error", n)
+			}
+		})
+	}
+}
+
+// A hookClock is a fakeClock calling hook whenever a timer is started.
+type hookClock struct {
+	*fakeClock
+	hook func()
+}
+
+func (c hookClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.hook()
+	return c.fakeClock.AfterFunc(d, f)
+}
+
+func TestLoadSchedulesOnceComplete(t *testing.T) {
+	journal := new(bytes.Buffer)
+	ww := csv.NewWriter(journal)
+	for _, r := range fullReminders() {
+		ww.Write(append([]string{"add"}, r.record()...))
+	}
+	ww.Flush()
+	snapshot := new(bytes.Buffer)
+	rs := newTestState(newFakeClock())
+	for _, r := range fullReminders() {
+		rs.Add(r, 0)
+	}
+	rs.WriteTo(snapshot)
+	tests := []struct {
+		name string
+		load func(rs *remindmeState) error
+	}{
+		{"replay", func(rs *remindmeState) error {
+			return rs.replay(bytes.NewReader(journal.Bytes()))
+		}},
+		{"ReadFrom", func(rs *remindmeState) error {
+			_, err := rs.ReadFrom(bytes.NewReader(snapshot.Bytes()))
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := newTestState(nil)
+			// Every timer, even of a reminder already due, is started with
+			// all the reminders in place.
+			rs.clock = hookClock{newFakeClock(), func() {
+				if n := len(rs.reminders); n != len(fullReminders()) {
+					t.Errorf("timer started with %d of %d reminders loaded", n, len(fullReminders()))
+				}
+			}}
+			err := test.load(rs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOrder(t, rs, []string{"bbbbbb", "aaaaaa", "cccccc"})
+		})
+	}
+}
+
+func TestReadFilesAllOrNothing(t *testing.T) {
+	good := fullReminders()[2]
+	trigger := "m1,1h," + strings.Join(good.record(), ",")
+	tests := []struct {
+		name  string
+		read  func(rs *remindmeState, r io.Reader) error
+		file  string
+		empty func(rs *remindmeState) bool
+	}{
+		{"timezones", (*remindmeState).readZones,
+			"u,Europe/Paris\nv,Mars/Olympus_Mons\n",
+			func(rs *remindmeState) bool { return len(rs.zones) == 0 }},
+		{"prefixes", (*remindmeState).readPrefixes,
+			"g,!r\nh\n",
+			func(rs *remindmeState) bool { return len(rs.prefixes) == 0 }},
+		{"pauses", (*remindmeState).readPauses,
+			"u,2020-01-01T00:00:00Z\nv,yesterday\n",
+			func(rs *remindmeState) bool { return len(rs.paused) == 0 }},
+		{"templates", (*remindmeState).readTemplates,
+			"u,name,message\nv,name\n",
+			func(rs *remindmeState) bool { return len(rs.templates) == 0 }},
+		{"channels", (*remindmeState).readChannels,
+			"u,200000000000000001\nv\n",
+			func(rs *remindmeState) bool { return len(rs.channels) == 0 }},
+		{"triggers", (*remindmeState).readTriggers,
+			trigger + "\nm2,soon," + strings.Join(good.record(), ",") + "\n",
+			func(rs *remindmeState) bool { return len(rs.triggers) == 0 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			rs := newTestState(c)
+			err := test.read(rs, strings.NewReader(test.file))
+			if err == nil {
+				t.Fatal("read an invalid file without error")
+			}
+			if !test.empty(rs) || c.active() != 0 {
+				t.Errorf("failing to read left part of the file read, with %d timers", c.active())
+			}
+		})
+	}
+}
+
+func TestBroadcastShutdown(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	done := rmState.done
+	defer func() { rmState.done = done }()
+	rmState.done = make(chan struct{})
+	const owner = "100000000000000001"
+	ownerID = owner
+	defer func() { ownerID = "" }()
+	for _, userID := range []string{"100000000000000002", "100000000000000003"} {
+		err := rmState.Add(testReminder(userID, userID[len(userID)-6:], 0, day), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd := new(fakeDiscord)
+	s := newFakeSession(fd)
+	s.State.User = &discordgo.User{ID: "100000000000000000", Bot: true}
+	rmState.session = s
+	broadcastCommand := func(id string) {
+		remindmeHandler(s, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        id,
+			ChannelID: "200000000000000001",
+			GuildID:   "600000000000000001",
+			Content:   "!remindme broadcast hello",
+			Author:    &discordgo.User{ID: owner, Username: "owner"},
+		}})
+	}
+	defer rateLimits.Delete(owner)
+
+	broadcastCommand("500000000000000001")
+	rmState.Lock()
+	close(rmState.done)
+	rmState.Unlock()
+	// Shutting down waits for the broadcast to stop, report and finish.
+	finished := make(chan struct{})
+	go func() {
+		rmState.deliveries.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast did not finish after shutting down")
+	}
+	fd.mu.Lock()
+	sent := fd.sent
+	fd.mu.Unlock()
+	if len(sent) == 0 || !strings.HasPrefix(sent[len(sent)-1].Content, "broadcast to ") {
+		t.Fatalf("broadcast did not report before finishing: %+v", sent)
+	}
+
+	broadcastCommand("500000000000000002")
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if last := fd.sent[len(fd.sent)-1]; last.Content != "shutting down, not broadcasting" {
+		t.Errorf("broadcast while shutting down replied %q", last.Content)
+	}
+}
+
+func TestReloadKeepsJournal(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	defer func() {
+		rmState.Lock()
+		rmState.closeJournal()
+		rmState.Unlock()
+	}()
+	err := rmState.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A snapshot from before the journaled changes, as left by the last
+	// shutdown, must not win over them.
+	stale := "snapshot,2\nu,2020-01-01T00:00:00Z,2020-01-02T00:00:00Z,stale,cccccc,u,false\nend,1\n"
+	err = ioutil.WriteFile(filepath.Join(remindersDirname, "reminders-2020-01-01T00:00:00Z.csv"), []byte(stale), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"aaaaaa", "bbbbbb"} {
+		err := rmState.Add(testReminder("u", id, 0, day), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rmState.Remove("u", "bbbbbb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmState.Lock()
+	rmState.recordFired(rmState.reminders[0])
+	rmState.acks = map[string]pendingAck{"m": {userID: "u", id: "aaaaaa"}}
+	rmState.Unlock()
+
+	reloadRMState()
+	checkOrder(t, &rmState, []string{"aaaaaa"})
+	if rmState.fired != nil || rmState.acks != nil {
+		t.Errorf("reload kept fired %v and acks %v", rmState.fired, rmState.acks)
+	}
+	// Reloading again finds the same, as the journal was rewritten from
+	// what was replayed rather than from the snapshot.
+	reloadRMState()
+	checkOrder(t, &rmState, []string{"aaaaaa"})
+}
+
+// benchState returns a state holding 10k reminders spread over 100 users,
+// with the ids of the reminders in the order they were added.
+func benchState(b *testing.B) (*remindmeState, []*reminder) {
+	rs := newTestState(newFakeClock())
+	var added []*reminder
+	for n := 0; n < 10000; n++ {
+		r := testReminder(fmt.Sprintf("user%02d", n%100), fmt.Sprintf("%06x", n),
+			0, time.Duration(n*7919%10000)*time.Minute)
+		err := rs.Add(r, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		added = append(added, r)
+	}
+	return rs, added
+}
+
+// BenchmarkFind10k looks up reminders by id, as cancel, edit and snooze do.
+func BenchmarkFind10k(b *testing.B) {
+	rs, added := benchState(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r := added[n%len(added)]
+		rs.Lock()
+		k := rs.find(r.userID, r.id)
+		rs.Unlock()
+		if k == -1 {
+			b.Fatal("reminder not found")
+		}
+	}
+}
+
+// BenchmarkAddRemove10k adds a reminder to and removes it from a state
+// holding 10k others.
+func BenchmarkAddRemove10k(b *testing.B) {
+	rs, _ := benchState(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r := testReminder("user50", "", 0, time.Duration(n%10000)*time.Minute)
+		err := rs.Add(r, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = rs.Remove(r.userID, r.id)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
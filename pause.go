@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+const pausesFilename = "pauses.csv"
+
+// Paused returns when userID paused their reminders, or the zero time if
+// they have not.
+func (rs *remindmeState) Paused(userID string) time.Time {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.paused[userID]
+}
+
+// isPaused reports whether userID's reminders are paused.
+// The lock must be held.
+func (rs *remindmeState) isPaused(userID string) bool {
+	_, ok := rs.paused[userID]
+	return ok
+}
+
+// Pause stops userID's reminders from going off until Resume. Reminders
+// set for them meanwhile are kept without going off as well. It reports
+// whether they were not paused already.
+func (rs *remindmeState) Pause(userID string) bool {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.isPaused(userID) {
+		return false
+	}
+	if rs.paused == nil {
+		rs.paused = make(map[string]time.Time)
+	}
+	since := rs.now()
+	rs.paused[userID] = since
+	i, j := rs.userRange(userID)
+	for k := i; k < j; k++ {
+		rs.timers[k].Stop()
+		rs.stopWarning(rs.reminders[k])
+	}
+	rs.appendJournal("pause", userID, since.Format(time.RFC3339Nano))
+	logger.User(userID).Infof("Paused %d reminders for %s", j-i, userID)
+	return true
+}
+
+// Resume lets userID's reminders go off again after Pause. Those that came
+// due while paused go off right away; the rest keep their times. It returns
+// the number resumed and how many of them came due while paused, or -1 if
+// the reminders were not paused.
+func (rs *remindmeState) Resume(userID string) (resumed, due int) {
+	rs.Lock()
+	defer rs.Unlock()
+	if !rs.isPaused(userID) {
+		return -1, 0
+	}
+	delete(rs.paused, userID)
+	now := rs.clock.Now()
+	i, j := rs.userRange(userID)
+	for k := i; k < j; k++ {
+		r := rs.reminders[k]
+		// Pending reminders are already retried by RetryPending.
+		if r.pending {
+			continue
+		}
+		rs.timers[k].Stop()
+		rs.timers[k] = rs.schedule(r)
+		resumed++
+		if r.expiration.Before(now) {
+			due++
+		}
+	}
+	rs.appendJournal("resume", userID)
+	logger.User(userID).Infof("Resumed %d reminders for %s, %d of them due", resumed, userID, due)
+	return resumed, due
+}
+
+func (rs *remindmeState) readPauses(r io.Reader) error {
+	rr := csv.NewReader(r)
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	paused := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("invalid pause record: %s", record)
+		}
+		since, err := time.Parse(time.RFC3339Nano, record[1])
+		if err != nil {
+			return fmt.Errorf("invalid pause record: %s", record)
+		}
+		paused[record[0]] = since
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.paused == nil {
+		rs.paused = make(map[string]time.Time)
+	}
+	for userID, since := range paused {
+		rs.paused[userID] = since
+	}
+	return nil
+}
+
+func (rs *remindmeState) writePauses(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for userID, since := range rs.paused {
+		ww.Write([]string{userID, since.Format(time.RFC3339Nano)})
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pausedState returns a state delivering through fd, in which userID has
+// reminders going off 1h, 2h and 3h after fakeEpoch and one going off 1d
+// after it, and has paused them.
+func pausedState(t *testing.T, c *fakeClock, fd *fakeDiscord, userID string) *remindmeState {
+	t.Helper()
+	rs := newTestState(c)
+	rs.session = newFakeSession(fd)
+	addAllOrFail(t, rs,
+		testReminder(userID, "aaaaaa", 0, time.Hour),
+		testReminder(userID, "bbbbbb", 0, 2*time.Hour),
+		testReminder(userID, "cccccc", 0, 3*time.Hour),
+		testReminder(userID, "dddddd", 0, day))
+	if !rs.Pause(userID) {
+		t.Fatal("Pause failed")
+	}
+	return rs
+}
+
+func TestPauseHoldsReminders(t *testing.T) {
+	c := newFakeClock()
+	fd := new(fakeDiscord)
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(1, 1, time.Microsecond))()
+	const userID = "100000000000000001"
+	rs := pausedState(t, c, fd, userID)
+	if rs.Pause(userID) {
+		t.Error("Pause of paused reminders succeeded")
+	}
+	addAllOrFail(t, rs, testReminder(userID, "eeeeee", 0, 90*time.Minute))
+	c.Advance(4 * time.Hour)
+	if len(fd.sent) != 0 {
+		t.Fatalf("sent %d reminders while paused", len(fd.sent))
+	}
+	if c.active() != 0 {
+		t.Errorf("%d timers running while paused", c.active())
+	}
+	checkOrder(t, rs, []string{"aaaaaa", "eeeeee", "bbbbbb", "cccccc", "dddddd"})
+	if since := rs.Paused(userID); !since.Equal(fakeEpoch) {
+		t.Errorf("paused since %v, want %v", since, fakeEpoch)
+	}
+}
+
+// TestResumeDeliversOverdueAtOnce pins down that the reminders that came
+// due while paused all go off at once on resume, in the order they were
+// due, rather than spread out. Throttling them is left to deliveryThrottle.
+func TestResumeDeliversOverdueAtOnce(t *testing.T) {
+	c := newFakeClock()
+	fd := new(fakeDiscord)
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(1, 1, time.Microsecond))()
+	const userID = "100000000000000001"
+	rs := pausedState(t, c, fd, userID)
+	c.Advance(4 * time.Hour)
+	resumed, due := rs.Resume(userID)
+	if resumed != 4 || due != 3 {
+		t.Errorf("Resume = %d, %d; want 4, 3", resumed, due)
+	}
+	c.Advance(0)
+	if len(fd.sent) != 3 {
+		t.Fatalf("resuming sent %d reminders at once, want the 3 due", len(fd.sent))
+	}
+	for n, want := range []string{"message aaaaaa", "message bbbbbb", "message cccccc"} {
+		if got := fd.sent[n].Content; !strings.Contains(got, want) {
+			t.Errorf("reminder %d sent was %q, want %s", n+1, got, want)
+		}
+	}
+	checkOrder(t, rs, []string{"dddddd"})
+	// The rest keep their times.
+	c.Advance(day - 4*time.Hour - time.Second)
+	if len(fd.sent) != 3 {
+		t.Fatal("reminder not due went off early on resume")
+	}
+	c.Advance(time.Second)
+	if len(fd.sent) != 4 {
+		t.Fatal("reminder not due did not go off at its time")
+	}
+	if resumed, _ := rs.Resume(userID); resumed != -1 {
+		t.Errorf("Resume of reminders not paused = %d, want -1", resumed)
+	}
+}
+
+func TestResumeQueueFull(t *testing.T) {
+	c := newFakeClock()
+	fd := new(fakeDiscord)
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(1, 0, time.Microsecond))()
+	const userID = "100000000000000001"
+	rs := pausedState(t, c, fd, userID)
+	c.Advance(4 * time.Hour)
+	// With no room to queue the burst, the reminders due are left
+	// pending, not lost.
+	if err := deliveryThrottle.acquire(rs.done, &reminder{}); err != nil {
+		t.Fatal(err)
+	}
+	rs.Resume(userID)
+	c.Advance(0)
+	if len(fd.sent) != 0 {
+		t.Fatalf("sent %d reminders with the queue full", len(fd.sent))
+	}
+	pending := 0
+	for _, r := range rs.reminders {
+		if r.pending {
+			pending++
+		}
+	}
+	if pending != 3 {
+		t.Fatalf("%d reminders left pending, want the 3 due", pending)
+	}
+	deliveryThrottle.release()
+	rs.RetryPending()
+	if len(fd.sent) != 3 {
+		t.Fatalf("retrying sent %d reminders, want 3", len(fd.sent))
+	}
+	checkOrder(t, rs, []string{"dddddd"})
+}
+
+func TestPauseReplay(t *testing.T) {
+	c := newFakeClock()
+	bb := new(bytes.Buffer)
+	ww := csv.NewWriter(bb)
+	for _, r := range []*reminder{testReminder("u", "aaaaaa", 0, time.Hour), testReminder("v", "bbbbbb", 0, time.Hour)} {
+		ww.Write(append([]string{"add"}, r.record()...))
+	}
+	ww.Write([]string{"pause", "u", fakeEpoch.Format(time.RFC3339Nano)})
+	ww.Write([]string{"pause", "v", fakeEpoch.Format(time.RFC3339Nano)})
+	ww.Write([]string{"resume", "v"})
+	ww.Flush()
+	rs := newTestState(c)
+	err := rs.replay(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.Paused("u").Equal(fakeEpoch) || !rs.Paused("v").IsZero() {
+		t.Fatalf("replayed pauses %v, want only u's", rs.paused)
+	}
+	// Only v's reminder has a timer running.
+	if n := c.active(); n != 1 {
+		t.Errorf("%d timers running after replay, want 1", n)
+	}
+}
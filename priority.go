@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// Reminders going off together are delivered in order of priority, then
+// of expiration.
+const (
+	lowPriority    = -1
+	normalPriority = 0
+	highPriority   = 1
+)
+
+// parsePriority parses a priority given as low, normal or high.
+func parsePriority(s string) (int, error) {
+	switch s {
+	case "low":
+		return lowPriority, nil
+	case "", "normal":
+		return normalPriority, nil
+	case "high":
+		return highPriority, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q; write low, normal or high", s)
+}
+
+// formatPriority formats a reminder's priority for its record: empty for
+// normal.
+func formatPriority(priority int) string {
+	switch priority {
+	case lowPriority:
+		return "low"
+	case highPriority:
+		return "high"
+	}
+	return ""
+}
+
+// deliversBefore reports whether a is to be delivered before b when both
+// are due.
+func deliversBefore(a, b *reminder) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.expiration.Before(b.expiration)
+}
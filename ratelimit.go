@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Each user may send rateBurst commands at once, after which they regain
+// one every rateInterval/rateBurst.
+var (
+	rateBurst    = defaultRateBurst
+	rateInterval = defaultRateInterval
+)
+
+const (
+	defaultRateBurst    = 5
+	defaultRateInterval = 10 * time.Second
+)
+
+// A tokenBucket allows rateBurst events at once, refilling over
+// rateInterval.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens regained since the last event.
+// The bucket's lock must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += float64(rateBurst) * float64(now.Sub(b.last)) / float64(rateInterval)
+	if b.tokens > float64(rateBurst) {
+		b.tokens = float64(rateBurst)
+	}
+	b.last = now
+}
+
+// take reports whether an event is allowed now, using up a token if so.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled completely, so that
+// forgetting it changes nothing.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= float64(rateBurst)
+}
+
+// rateLimits holds a *tokenBucket for each user who sent a command
+// recently.
+var rateLimits sync.Map
+
+// allowCommand reports whether userID may send another command at now.
+func allowCommand(userID string, now time.Time) bool {
+	b, _ := rateLimits.LoadOrStore(userID, &tokenBucket{
+		tokens: float64(rateBurst),
+		last:   now,
+	})
+	return b.(*tokenBucket).take(now)
+}
+
+// pruneRateLimits forgets the buckets of users who have not sent commands
+// in a while, as of now.
+func pruneRateLimits(now time.Time) {
+	rateLimits.Range(func(userID, b interface{}) bool {
+		if b.(*tokenBucket).full(now) {
+			rateLimits.Delete(userID)
+		}
+		return true
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestAllowCommand(t *testing.T) {
+	c := newFakeClock()
+	userID := "ratelimit-burst"
+	for n := 0; n < rateBurst; n++ {
+		if !allowCommand(userID, c.Now()) {
+			t.Fatalf("command %d of the burst was not allowed", n+1)
+		}
+	}
+	if allowCommand(userID, c.Now()) {
+		t.Fatal("command past the burst was allowed")
+	}
+	c.Advance(rateInterval / time.Duration(rateBurst))
+	if !allowCommand(userID, c.Now()) {
+		t.Fatal("command was not allowed after regaining a token")
+	}
+	if allowCommand(userID, c.Now()) {
+		t.Fatal("command was allowed after using up the regained token")
+	}
+}
+
+func TestPruneRateLimits(t *testing.T) {
+	c := newFakeClock()
+	userID := "ratelimit-prune"
+	allowCommand(userID, c.Now())
+	pruneRateLimits(c.Now())
+	if _, ok := rateLimits.Load(userID); !ok {
+		t.Fatal("pruned the bucket of a user who just sent a command")
+	}
+	c.Advance(rateInterval)
+	pruneRateLimits(c.Now())
+	if _, ok := rateLimits.Load(userID); ok {
+		t.Fatal("kept the bucket of a user whose bucket refilled")
+	}
+}
+
+func TestInteractionRateLimit(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	fd := new(fakeDiscord)
+	s := newFakeSession(fd)
+	s.State.User = &discordgo.User{ID: "100000000000000000", Bot: true}
+	rmState.session = s
+	user := &discordgo.User{ID: "100000000000000007", Username: "user"}
+	defer rateLimits.Delete(user.ID)
+	for n := 0; n <= rateBurst; n++ {
+		interactionHandler(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			ID:    fmt.Sprint(700000000000000000 + n),
+			Type:  discordgo.InteractionApplicationCommand,
+			Token: "token",
+			User:  user,
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name: remindmeCommand.Name,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+					Name: "list",
+					Type: discordgo.ApplicationCommandOptionSubCommand,
+				}},
+			},
+		}})
+	}
+	if len(fd.responses) != rateBurst+1 {
+		t.Fatalf("got %d responses, want %d", len(fd.responses), rateBurst+1)
+	}
+	for n, content := range fd.responses[:rateBurst] {
+		if content != "you have no reminders" {
+			t.Errorf("response %d is %q, want the list", n+1, content)
+		}
+	}
+	if last := fd.responses[rateBurst]; !strings.Contains(last, "too quickly") {
+		t.Errorf("response past the burst is %q, want it rate limited", last)
+	}
+}
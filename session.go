@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// tokenFile is the file the bot token is read from, set with
+// REMINDME_TOKEN_FILE. If it is set, sending the process SIGUSR1 rereads it
+// and swaps in a session opened with the new token, so that the token can
+// be rotated without restarting.
+var tokenFile string
+
+// removeGatewayHandlers removes the gateway handlers of the session in use.
+var removeGatewayHandlers func()
+
+func readToken() (string, error) {
+	if tokenFile == "" {
+		return "", fmt.Errorf("REMINDME_TOKEN_FILE is not set")
+	}
+	b, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+// addHandlers registers the bot's handlers, other than the gateway
+// handlers, on s.
+func addHandlers(s *discordgo.Session) {
+	s.AddHandler(remindmeHandler)
+	s.AddHandler(interactionHandler)
+	s.AddHandler(ackHandler)
+	s.AddHandler(replyHandler)
+	s.AddHandler(triggerDeleteHandler)
+}
+
+// Session returns the session in use.
+func (rs *remindmeState) Session() *discordgo.Session {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.session
+}
+
+// reloadToken opens a session with the token in tokenFile and swaps it in
+// for the session in use, which is then closed. If the token cannot be read
+// or the new session cannot be opened, as when the token is invalid, the
+// session in use is kept and the error returned. While both sessions are
+// open, a message may be handled by both of them.
+func reloadToken() error {
+	token, err := readToken()
+	if err != nil {
+		return err
+	}
+	s, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return err
+	}
+	removeGateway := registerGatewayHandlers(s)
+	addHandlers(s)
+	err = s.Open()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	rmState.Lock()
+	old := rmState.session
+	rmState.session = s
+	rmState.Unlock()
+	// The old session going down must not mark the gateway as down.
+	if removeGatewayHandlers != nil {
+		removeGatewayHandlers()
+	}
+	removeGatewayHandlers = removeGateway
+	gateway.set(true)
+	err = old.Close()
+	if err != nil {
+		logger.Error("unable to close the old session: ", err)
+	}
+	return nil
+}
+
+// handleTokenReloads reloads the token on every SIGUSR1.
+func handleTokenReloads() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	for range sigs {
+		logger.Info("Reloading the bot token.")
+		err := reloadToken()
+		if err != nil {
+			logger.Error("unable to reload the bot token, keeping the old session: ", err)
+			continue
+		}
+		logger.Info("Session reopened with the new token.")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A sharded snapshot is a directory named like a snapshot file but ending
+// in shardsSuffix, holding one snapshot file per shard of users, named by
+// the shard in hexadecimal: 0.csv to f.csv. It is written to a directory
+// ending in .tmp beside it first, which is then renamed, so that it is
+// never seen partially written.
+const (
+	shardsSuffix   = ".shards"
+	snapshotShards = 16
+)
+
+// shardOf returns the shard that userID's reminders are stored in.
+func shardOf(userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return int(h.Sum32() % snapshotShards)
+}
+
+func shardName(shard int) string {
+	return strconv.FormatInt(int64(shard), 16) + remindersFileSuffix
+}
+
+// writeSnapshot writes a snapshot of the reminders that keep returns true
+// for, or of all of them if keep is nil.
+func (rs *remindmeState) writeSnapshot(w io.Writer, keep func(r *reminder) bool) (int64, error) {
+	bb := new(bytes.Buffer)
+	ww := csv.NewWriter(bb)
+	ww.Write(append([]string{snapshotHeader, snapshotVersion}, reminderColumns[:]...))
+	n := 0
+	rs.Lock()
+	for _, r := range rs.reminders {
+		if keep == nil || keep(r) {
+			ww.Write(r.record())
+			n++
+		}
+	}
+	rs.Unlock()
+	ww.Write([]string{snapshotTrailer, strconv.Itoa(n)})
+	ww.Flush()
+	if err := ww.Error(); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, bb)
+}
+
+// writeShardedSnapshot writes a sharded snapshot of the reminders to dir.
+func (rs *remindmeState) writeShardedSnapshot(dir string) error {
+	tmp := dir + ".tmp"
+	err := os.Mkdir(tmp, 0700)
+	if err != nil {
+		return err
+	}
+	for shard := 0; shard < snapshotShards; shard++ {
+		shard := shard
+		err = writeFileAtomic(filepath.Join(tmp, shardName(shard)), func(w io.Writer) error {
+			_, err := rs.writeSnapshot(w, func(r *reminder) bool {
+				return shardOf(r.userID) == shard
+			})
+			return err
+		})
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// readShardedSnapshot parses the reminders of the sharded snapshot in dir,
+// failing if any shard is missing or incomplete.
+func readShardedSnapshot(dir string) ([]*reminder, error) {
+	var reminders []*reminder
+	for shard := 0; shard < snapshotShards; shard++ {
+		f, err := os.Open(filepath.Join(dir, shardName(shard)))
+		if err != nil {
+			return nil, err
+		}
+		shardReminders, err := readSnapshot(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", shardName(shard), err)
+		}
+		reminders = append(reminders, shardReminders...)
+	}
+	return reminders, nil
+}
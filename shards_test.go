@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fullReminders returns reminders using every field of a record.
+func fullReminders() []*reminder {
+	return []*reminder{{
+		id:          "aaaaaa",
+		userID:      "100000000000000001",
+		authorID:    "100000000000000002",
+		creation:    fakeEpoch,
+		expiration:  fakeEpoch.Add(time.Hour),
+		message:     "pay rent",
+		channelID:   "200000000000000001",
+		tags:        []string{"home", "bills"},
+		quote:       "1/2/3",
+		attachments: []string{"https://example.com/a.png"},
+		zone:        "Europe/Paris",
+		warn:        10 * time.Minute,
+		priority:    highPriority,
+		after:       "bbbbbb",
+		label:       "rent",
+		pending:     true,
+	}, {
+		id:         "bbbbbb",
+		userID:     "100000000000000001",
+		authorID:   "100000000000000001",
+		creation:   fakeEpoch,
+		expiration: fakeEpoch.Add(30 * time.Minute),
+		message:    "stand up",
+		daily:      "09:30",
+		priority:   lowPriority,
+	}, {
+		id:         "cccccc",
+		userID:     "100000000000000003",
+		authorID:   "100000000000000003",
+		creation:   fakeEpoch.Add(time.Second),
+		expiration: fakeEpoch.Add(day),
+		message:    "water the plants",
+	}}
+}
+
+// reminderStrings returns the String of each reminder, for comparing.
+func reminderStrings(reminders []*reminder) string {
+	var s []string
+	for _, r := range reminders {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, "\n")
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	rs := newTestState(newFakeClock())
+	for _, r := range fullReminders() {
+		rs.Add(r, 0)
+	}
+	bb := new(bytes.Buffer)
+	_, err := rs.WriteTo(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "snapshot,3," + strings.Join(reminderColumns[:], ",") + "\n"
+	if !strings.HasPrefix(bb.String(), header) {
+		t.Fatalf("snapshot does not start with %q:\n%s", header, bb)
+	}
+	reminders, err := readSnapshot(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reminderStrings(reminders), reminderStrings(rs.reminders); got != want {
+		t.Errorf("read back\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestShardedSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remindme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rs := newTestState(newFakeClock())
+	for _, r := range fullReminders() {
+		rs.Add(r, 0)
+	}
+	name := filepath.Join(dir, remindersFilePrefix+"x"+shardsSuffix)
+	err = rs.writeShardedSnapshot(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminders, err := readShardedSnapshot(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := newTestState(newFakeClock())
+	for _, r := range reminders {
+		read.Add(r, 0)
+	}
+	if got, want := reminderStrings(read.reminders), reminderStrings(rs.reminders); got != want {
+		t.Errorf("read back\n%s\nwant\n%s", got, want)
+	}
+	os.Remove(filepath.Join(name, shardName(snapshotShards-1)))
+	_, err = readShardedSnapshot(name)
+	if err == nil {
+		t.Error("read a sharded snapshot missing a shard")
+	}
+}
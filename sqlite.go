@@ -0,0 +1,542 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// When a database is set with REMINDME_DB, the state is stored in SQLite
+// in place of the journal: every event is written through to it as soon as
+// it happens. The first time, the database is filled from the journal or
+// the newest snapshot.
+const dbSchema = `
+CREATE TABLE IF NOT EXISTS reminders (
+	id          TEXT PRIMARY KEY,
+	user_id     TEXT NOT NULL,
+	author_id   TEXT NOT NULL,
+	creation    INTEGER NOT NULL,
+	expiration  INTEGER NOT NULL,
+	message     TEXT NOT NULL,
+	pending     INTEGER NOT NULL,
+	channel_id  TEXT NOT NULL,
+	tags        TEXT NOT NULL DEFAULT '',
+	daily       TEXT NOT NULL DEFAULT '',
+	quote       TEXT NOT NULL DEFAULT '',
+	warn        INTEGER NOT NULL DEFAULT 0,
+	zone        TEXT NOT NULL DEFAULT '',
+	attachments TEXT NOT NULL DEFAULT '',
+	priority    INTEGER NOT NULL DEFAULT 0,
+	after_id    TEXT NOT NULL DEFAULT '',
+	label       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS reminders_user_expiration ON reminders (user_id, expiration);
+CREATE TABLE IF NOT EXISTS zones (
+	user_id TEXT PRIMARY KEY,
+	zone    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS prefixes (
+	guild_id TEXT PRIMARY KEY,
+	prefix   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pauses (
+	user_id TEXT PRIMARY KEY,
+	since   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS templates (
+	user_id TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	message TEXT NOT NULL,
+	PRIMARY KEY (user_id, name)
+);
+CREATE TABLE IF NOT EXISTS channels (
+	user_id    TEXT PRIMARY KEY,
+	channel_id TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS triggers (
+	message_id  TEXT PRIMARY KEY,
+	duration    INTEGER NOT NULL,
+	id          TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	author_id   TEXT NOT NULL,
+	creation    INTEGER NOT NULL,
+	expiration  INTEGER NOT NULL,
+	message     TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	tags        TEXT NOT NULL,
+	quote       TEXT NOT NULL,
+	warn        INTEGER NOT NULL,
+	zone        TEXT NOT NULL,
+	attachments TEXT NOT NULL,
+	priority    INTEGER NOT NULL DEFAULT 0,
+	label       TEXT NOT NULL DEFAULT ''
+);
+`
+
+// dbPath is the SQLite database to store the state in, or empty to use
+// the journal.
+var dbPath string
+
+// dbExecer is satisfied by both *sql.DB and *sql.Tx.
+type dbExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// dbColumns are the columns added to the schema after its first version,
+// with their definitions.
+var dbColumns = []struct{ table, column, definition string }{
+	{"reminders", "tags", "TEXT NOT NULL DEFAULT ''"},
+	{"reminders", "daily", "TEXT NOT NULL DEFAULT ''"},
+	{"reminders", "quote", "TEXT NOT NULL DEFAULT ''"},
+	{"reminders", "warn", "INTEGER NOT NULL DEFAULT 0"},
+	{"reminders", "zone", "TEXT NOT NULL DEFAULT ''"},
+	{"reminders", "attachments", "TEXT NOT NULL DEFAULT ''"},
+	{"reminders", "priority", "INTEGER NOT NULL DEFAULT 0"},
+	{"reminders", "after_id", "TEXT NOT NULL DEFAULT ''"},
+	{"triggers", "priority", "INTEGER NOT NULL DEFAULT 0"},
+	{"reminders", "label", "TEXT NOT NULL DEFAULT ''"},
+	{"triggers", "label", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateDB adds any of dbColumns missing from a database created by an
+// older version.
+func migrateDB(db *sql.DB) error {
+	for _, c := range dbColumns {
+		rows, err := db.Query(`PRAGMA table_info(` + c.table + `)`)
+		if err != nil {
+			return err
+		}
+		found := false
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, typ string
+			var dflt sql.NullString
+			err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			found = found || name == c.column
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if !found {
+			_, err = db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func insertReminder(db dbExecer, r *reminder) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO reminders
+		(id, user_id, author_id, creation, expiration, message, pending, channel_id, tags, daily, quote, warn, zone, attachments, priority, after_id, label)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.id, r.userID, r.authorID, r.creation.UnixNano(), r.expiration.UnixNano(),
+		r.message, r.pending, r.channelID, strings.Join(r.tags, " "), r.daily, r.quote, int64(r.warn), r.zone,
+		strings.Join(r.attachments, " "), r.priority, r.after, r.label)
+	return err
+}
+
+// insertTrigger stores t. Its reminder is never pending or daily, so those
+// are not stored.
+func insertTrigger(db dbExecer, t *trigger) error {
+	r := t.r
+	_, err := db.Exec(`INSERT OR REPLACE INTO triggers
+		(message_id, duration, id, user_id, author_id, creation, expiration, message, channel_id, tags, quote, warn, zone, attachments, priority, label)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.messageID, int64(t.duration), r.id, r.userID, r.authorID, r.creation.UnixNano(), r.expiration.UnixNano(),
+		r.message, r.channelID, strings.Join(r.tags, " "), r.quote, int64(r.warn), r.zone,
+		strings.Join(r.attachments, " "), r.priority, r.label)
+	return err
+}
+
+// applyDB writes a journal event through to the database.
+// The lock must be held.
+func (rs *remindmeState) applyDB(event []string) error {
+	var err error
+	switch event[0] {
+	case "add":
+		var r *reminder
+		r, err = parseReminder(event[1:])
+		if err == nil {
+			err = insertReminder(rs.db, r)
+		}
+	case "remove":
+		_, err = rs.db.Exec(`DELETE FROM reminders WHERE user_id = ? AND id = ?`,
+			event[1], event[2])
+	case "zone":
+		if event[2] == time.UTC.String() {
+			_, err = rs.db.Exec(`DELETE FROM zones WHERE user_id = ?`, event[1])
+		} else {
+			_, err = rs.db.Exec(`INSERT OR REPLACE INTO zones (user_id, zone) VALUES (?, ?)`,
+				event[1], event[2])
+		}
+	case "prefix":
+		if event[2] == defaultPrefix {
+			_, err = rs.db.Exec(`DELETE FROM prefixes WHERE guild_id = ?`, event[1])
+		} else {
+			_, err = rs.db.Exec(`INSERT OR REPLACE INTO prefixes (guild_id, prefix) VALUES (?, ?)`,
+				event[1], event[2])
+		}
+	case "pause":
+		var since time.Time
+		since, err = time.Parse(time.RFC3339Nano, event[2])
+		if err == nil {
+			_, err = rs.db.Exec(`INSERT OR REPLACE INTO pauses (user_id, since) VALUES (?, ?)`,
+				event[1], since.UnixNano())
+		}
+	case "resume":
+		_, err = rs.db.Exec(`DELETE FROM pauses WHERE user_id = ?`, event[1])
+	case "template":
+		if event[3] == "" {
+			_, err = rs.db.Exec(`DELETE FROM templates WHERE user_id = ? AND name = ?`,
+				event[1], event[2])
+		} else {
+			_, err = rs.db.Exec(`INSERT OR REPLACE INTO templates (user_id, name, message) VALUES (?, ?, ?)`,
+				event[1], event[2], event[3])
+		}
+	case "channel":
+		if event[2] == "" {
+			_, err = rs.db.Exec(`DELETE FROM channels WHERE user_id = ?`, event[1])
+		} else {
+			_, err = rs.db.Exec(`INSERT OR REPLACE INTO channels (user_id, channel_id) VALUES (?, ?)`,
+				event[1], event[2])
+		}
+	case "trigger":
+		var t *trigger
+		t, err = parseTrigger(event[1:])
+		if err == nil {
+			err = insertTrigger(rs.db, t)
+		}
+	case "untrigger":
+		_, err = rs.db.Exec(`DELETE FROM triggers WHERE message_id = ?`, event[1])
+	default:
+		err = fmt.Errorf("unknown event %s", event[0])
+	}
+	return err
+}
+
+// loadDB loads the state from db. The state must be empty and not yet
+// stored in db.
+func (rs *remindmeState) loadDB(db *sql.DB) error {
+	zones := make(map[string]*time.Location)
+	rows, err := db.Query(`SELECT user_id, zone FROM zones`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var userID, zone string
+		err = rows.Scan(&userID, &zone)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			logger.User(userID).Errorf("ignoring unknown timezone %s of %s: %v", zone, userID, err)
+			continue
+		}
+		zones[userID] = loc
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	prefixes := make(map[string]string)
+	rows, err = db.Query(`SELECT guild_id, prefix FROM prefixes`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var guildID, prefix string
+		err = rows.Scan(&guildID, &prefix)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		prefixes[guildID] = prefix
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	paused := make(map[string]time.Time)
+	rows, err = db.Query(`SELECT user_id, since FROM pauses`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var userID string
+		var since int64
+		err = rows.Scan(&userID, &since)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		paused[userID] = time.Unix(0, since).In(time.UTC)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var templates [][3]string
+	rows, err = db.Query(`SELECT user_id, name, message FROM templates`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var t [3]string
+		err = rows.Scan(&t[0], &t[1], &t[2])
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		templates = append(templates, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	channels := make(map[string]string)
+	rows, err = db.Query(`SELECT user_id, channel_id FROM channels`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var userID, channelID string
+		err = rows.Scan(&userID, &channelID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		channels[userID] = channelID
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var triggers []*trigger
+	rows, err = db.Query(`SELECT message_id, duration, id, user_id, author_id, creation, expiration, message, channel_id, tags, quote, warn, zone, attachments, priority, label
+		FROM triggers`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		t := &trigger{r: new(reminder)}
+		r := t.r
+		var duration, creation, expiration, warn int64
+		var tags, attachments string
+		err = rows.Scan(&t.messageID, &duration, &r.id, &r.userID, &r.authorID, &creation, &expiration,
+			&r.message, &r.channelID, &tags, &r.quote, &warn, &r.zone, &attachments, &r.priority, &r.label)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		t.duration = time.Duration(duration)
+		r.creation = time.Unix(0, creation).In(time.UTC)
+		r.expiration = time.Unix(0, expiration).In(time.UTC)
+		r.tags = strings.Fields(tags)
+		r.attachments = strings.Fields(attachments)
+		r.warn = time.Duration(warn)
+		triggers = append(triggers, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var reminders []*reminder
+	rows, err = db.Query(`SELECT id, user_id, author_id, creation, expiration, message, pending, channel_id, tags, daily, quote, warn, zone, attachments, priority, after_id, label
+		FROM reminders ORDER BY user_id, expiration`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		r := new(reminder)
+		var creation, expiration, warn int64
+		var tags, attachments string
+		err = rows.Scan(&r.id, &r.userID, &r.authorID, &creation, &expiration,
+			&r.message, &r.pending, &r.channelID, &tags, &r.daily, &r.quote, &warn, &r.zone, &attachments, &r.priority, &r.after, &r.label)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		r.creation = time.Unix(0, creation).In(time.UTC)
+		r.expiration = time.Unix(0, expiration).In(time.UTC)
+		r.tags = strings.Fields(tags)
+		r.attachments = strings.Fields(attachments)
+		r.warn = time.Duration(warn)
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rs.Lock()
+	rs.zones = zones
+	rs.prefixes = prefixes
+	rs.paused = paused
+	rs.channels = channels
+	for _, t := range templates {
+		rs.setTemplate(t[0], t[1], t[2])
+	}
+	for _, t := range triggers {
+		rs.addTrigger(t)
+	}
+	rs.addAll(reminders)
+	rs.Unlock()
+	return nil
+}
+
+// storeDB replaces the contents of the database with the current state.
+// The lock must be held.
+func (rs *remindmeState) storeDB() error {
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		for _, table := range []string{"reminders", "zones", "prefixes", "pauses", "templates", "channels", "triggers"} {
+			_, err := tx.Exec(`DELETE FROM ` + table)
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range rs.reminders {
+			err := insertReminder(tx, r)
+			if err != nil {
+				return err
+			}
+		}
+		for userID, loc := range rs.zones {
+			_, err := tx.Exec(`INSERT INTO zones (user_id, zone) VALUES (?, ?)`,
+				userID, loc.String())
+			if err != nil {
+				return err
+			}
+		}
+		for guildID, prefix := range rs.prefixes {
+			_, err := tx.Exec(`INSERT INTO prefixes (guild_id, prefix) VALUES (?, ?)`,
+				guildID, prefix)
+			if err != nil {
+				return err
+			}
+		}
+		for userID, since := range rs.paused {
+			_, err := tx.Exec(`INSERT INTO pauses (user_id, since) VALUES (?, ?)`,
+				userID, since.UnixNano())
+			if err != nil {
+				return err
+			}
+		}
+		for userID, templates := range rs.templates {
+			for name, message := range templates {
+				_, err := tx.Exec(`INSERT INTO templates (user_id, name, message) VALUES (?, ?, ?)`,
+					userID, name, message)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for userID, channelID := range rs.channels {
+			_, err := tx.Exec(`INSERT INTO channels (user_id, channel_id) VALUES (?, ?)`,
+				userID, channelID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range rs.triggers {
+			err := insertTrigger(tx, t)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// haveDriver reports whether the database driver name is registered.
+func haveDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// openDB loads the state from the database at path and starts storing
+// changes to it. A database that does not exist yet is created and filled
+// from the journal or the newest snapshot.
+func (rs *remindmeState) openDB(path string) error {
+	if !haveDriver("sqlite3") {
+		return fmt.Errorf("unable to open reminders database: built without cgo, which SQLite needs")
+	}
+	_, err := os.Stat(path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to open reminders database: %v", err)
+	}
+	if !exists {
+		err = loadRMFiles()
+		if err != nil {
+			return err
+		}
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to open reminders database: %v", err)
+	}
+	// Writes are serialized by the state lock anyway.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(dbSchema)
+	if err == nil {
+		err = migrateDB(db)
+	}
+	if err == nil && exists {
+		err = rs.loadDB(db)
+	}
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("unable to load reminders database: %v", err)
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	rs.db = db
+	if exists {
+		return nil
+	}
+	err = rs.storeDB()
+	if err != nil {
+		rs.closeDB()
+		// Leave no empty database behind to be loaded next time.
+		os.Remove(path)
+		return fmt.Errorf("unable to import reminders into database: %v", err)
+	}
+	logger.Infof("Imported %d reminders into %s", len(rs.reminders), path)
+	return nil
+}
+
+// closeDB stops storing changes to the state in the database.
+// The lock must be held.
+func (rs *remindmeState) closeDB() {
+	if rs.db == nil {
+		return
+	}
+	err := rs.db.Close()
+	if err != nil {
+		logger.Error("closing reminders database: ", err)
+	}
+	rs.db = nil
+}
@@ -0,0 +1,70 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadDBFile loads a new state from the database at path through a
+// connection of its own, so that it sees only what has been written to it.
+func loadDBFile(t *testing.T, path string) *remindmeState {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rs := newTestState(newFakeClock())
+	err = rs.loadDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestDBRoundTrip(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	path := filepath.Join(remindersDirname, "reminders.db")
+	err := rmState.openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		rmState.Lock()
+		rmState.closeDB()
+		rmState.Unlock()
+	}()
+	addAllOrFail(t, &rmState,
+		testReminder("u", "aaaaaa", 0, time.Hour),
+		testReminder("u", "bbbbbb", 0, 2*time.Hour),
+		testReminder("v", "cccccc", 0, 3*time.Hour))
+	err = rmState.Remove("u", "bbbbbb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rmState.Edit("v", "cccccc", "edited", fakeEpoch.Add(4*time.Hour)) {
+		t.Fatal("edit failed")
+	}
+	// Each change is written through as it is made, with the database
+	// still open.
+	rs := loadDBFile(t, path)
+	checkOrder(t, rs, []string{"aaaaaa", "cccccc"})
+	rmState.Lock()
+	defer rmState.Unlock()
+	for k, r := range rs.reminders {
+		want := strings.Join(rmState.reminders[k].record(), ",")
+		if got := strings.Join(r.record(), ","); got != want {
+			t.Errorf("loaded %s, want %s", got, want)
+		}
+	}
+	if r := rs.reminders[1]; r.message != "edited" || !r.expiration.Equal(fakeEpoch.Add(4*time.Hour)) {
+		t.Errorf("loaded edited reminder with %q at %v", r.message, r.expiration)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// The SQLite driver needs cgo. Without it, the bot still builds, but
+// REMINDME_DB cannot be used.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHaveDriver(t *testing.T) {
+	if haveDriver("no such driver") {
+		t.Error("found a driver that was never registered")
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	_, restore := useTestState(t)
+	defer restore()
+	err := rmState.openDB(filepath.Join(remindersDirname, "reminders.db"))
+	if !haveDriver("sqlite3") {
+		// Built with CGO_ENABLED=0.
+		if err == nil || !strings.Contains(err.Error(), "cgo") {
+			t.Fatalf("opening a database without cgo gave %v, want an error about cgo", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmState.Lock()
+	defer rmState.Unlock()
+	if rmState.db == nil {
+		t.Fatal("database not in use after opening it")
+	}
+	rmState.closeDB()
+}
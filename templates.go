@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+const (
+	templatesFilename = "templates.csv"
+	// Each user may save at most this many templates.
+	maxTemplates = 25
+)
+
+// templateNameRe matches a valid template name.
+var templateNameRe = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+var errTooManyTemplates = fmt.Errorf("you already have the maximum of %d templates", maxTemplates)
+
+// Template returns the message of userID's template called name.
+func (rs *remindmeState) Template(userID string, name string) (string, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	message, ok := rs.templates[userID][name]
+	return message, ok
+}
+
+// Templates returns the names of userID's templates in order.
+func (rs *remindmeState) Templates(userID string) []string {
+	rs.Lock()
+	var names []string
+	for name := range rs.templates[userID] {
+		names = append(names, name)
+	}
+	rs.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// SetTemplate saves message as userID's template called name, replacing
+// any template of that name. An empty message deletes the template.
+func (rs *remindmeState) SetTemplate(userID string, name string, message string) error {
+	rs.Lock()
+	defer rs.Unlock()
+	if message == "" {
+		rs.setTemplate(userID, name, message)
+		rs.appendJournal("template", userID, name, message)
+		logger.User(userID).Infof("Deleted template %q for %s", name, userID)
+		return nil
+	}
+	if _, ok := rs.templates[userID][name]; !ok && len(rs.templates[userID]) >= maxTemplates {
+		return errTooManyTemplates
+	}
+	rs.setTemplate(userID, name, message)
+	rs.appendJournal("template", userID, name, message)
+	logger.User(userID).Infof("Saved template %q for %s with the message %q", name, userID, message)
+	return nil
+}
+
+// setTemplate is SetTemplate without journaling or limits.
+// The lock must be held.
+func (rs *remindmeState) setTemplate(userID string, name string, message string) {
+	if message == "" {
+		delete(rs.templates[userID], name)
+		if len(rs.templates[userID]) == 0 {
+			delete(rs.templates, userID)
+		}
+		return
+	}
+	if rs.templates == nil {
+		rs.templates = make(map[string]map[string]string)
+	}
+	if rs.templates[userID] == nil {
+		rs.templates[userID] = make(map[string]string)
+	}
+	rs.templates[userID][name] = message
+}
+
+func (rs *remindmeState) readTemplates(r io.Reader) error {
+	rr := csv.NewReader(r)
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if len(record) != 3 {
+			return fmt.Errorf("invalid template record: %s", record)
+		}
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	for _, record := range records {
+		rs.setTemplate(record[0], record[1], record[2])
+	}
+	return nil
+}
+
+func (rs *remindmeState) writeTemplates(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for userID, templates := range rs.templates {
+		for name, message := range templates {
+			ww.Write([]string{userID, name, message})
+		}
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// A throttle limits how many deliveries are attempted at once and paces
+// their starts. Deliveries waiting for their turn queue up on it, up to
+// max of them, and get it in the order of deliversBefore.
+type throttle struct {
+	mu      sync.Mutex
+	free    int
+	max     int
+	waiting []*throttleWaiter
+	tick    *time.Ticker
+}
+
+// errQueueFull is returned by acquire when too many deliveries are waiting
+// already. The reminder is then left pending, for RetryPending to deliver
+// once the queue has drained.
+var errQueueFull = errors.New("too many deliveries waiting")
+
+// A throttleWaiter is a delivery of r waiting for its turn, which has come
+// once ready is closed.
+type throttleWaiter struct {
+	r     *reminder
+	ready chan struct{}
+}
+
+var deliveryThrottle = newThrottle(concurrentDeliveries, maxQueuedDeliveries, deliveryInterval)
+
+func newThrottle(n, max int, interval time.Duration) *throttle {
+	return &throttle{
+		free: n,
+		max:  max,
+		tick: time.NewTicker(interval),
+	}
+}
+
+// acquire waits for a delivery of r to be allowed to start. It returns
+// errQueueFull right away if max deliveries are waiting already, or
+// errShuttingDown if done is closed first. Each successful acquire must be
+// followed by release once the delivery is over.
+func (t *throttle) acquire(done <-chan struct{}, r *reminder) error {
+	t.mu.Lock()
+	if t.free > 0 && len(t.waiting) == 0 {
+		t.free--
+		t.mu.Unlock()
+	} else if len(t.waiting) >= t.max {
+		t.mu.Unlock()
+		return errQueueFull
+	} else {
+		w := &throttleWaiter{r: r, ready: make(chan struct{})}
+		t.waiting = append(t.waiting, w)
+		t.mu.Unlock()
+		select {
+		case <-w.ready:
+		case <-done:
+			t.mu.Lock()
+			for k, other := range t.waiting {
+				if other == w {
+					t.waiting = append(t.waiting[:k], t.waiting[k+1:]...)
+					t.mu.Unlock()
+					return errShuttingDown
+				}
+			}
+			t.mu.Unlock()
+			// The turn came anyway, so pass it on.
+			t.release()
+			return errShuttingDown
+		}
+	}
+	select {
+	case <-t.tick.C:
+		return nil
+	case <-done:
+		t.release()
+		return errShuttingDown
+	}
+}
+
+// release ends a delivery, giving its turn to the first waiting delivery.
+func (t *throttle) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.waiting) == 0 {
+		t.free++
+		return
+	}
+	first := 0
+	for k, w := range t.waiting {
+		if deliversBefore(w.r, t.waiting[first].r) {
+			first = k
+		}
+	}
+	w := t.waiting[first]
+	t.waiting = append(t.waiting[:first], t.waiting[first+1:]...)
+	close(w.ready)
+}
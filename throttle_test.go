@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useThrottle makes deliveryThrottle t until the returned function is
+// called.
+func useThrottle(t *throttle) (restore func()) {
+	old := deliveryThrottle
+	deliveryThrottle = t
+	return func() {
+		t.tick.Stop()
+		deliveryThrottle = old
+	}
+}
+
+func TestThrottleQueueBound(t *testing.T) {
+	th := newThrottle(1, 2, time.Microsecond)
+	defer th.tick.Stop()
+	done := make(chan struct{})
+	r := &reminder{}
+	if err := th.acquire(done, r); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 2; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := th.acquire(done, r); err != errShuttingDown {
+				t.Errorf("waiting acquire returned %v, want errShuttingDown", err)
+			}
+		}()
+	}
+	waitFor(t, "two deliveries to queue up", func() bool {
+		th.mu.Lock()
+		defer th.mu.Unlock()
+		return len(th.waiting) == 2
+	})
+	if err := th.acquire(done, r); err != errQueueFull {
+		t.Errorf("acquire with a full queue returned %v, want errQueueFull", err)
+	}
+	close(done)
+	wg.Wait()
+	th.release()
+}
+
+func TestDeliveryQueueFull(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	fd := new(fakeDiscord)
+	rs.session = newFakeSession(fd)
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(1, 0, time.Microsecond))()
+	// Take the only turn, leaving no room to queue.
+	if err := deliveryThrottle.acquire(rs.done, &reminder{}); err != nil {
+		t.Fatal(err)
+	}
+	err := rs.Add(testReminder("100000000000000001", "aaaaaa", 0, time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Minute)
+	rs.Lock()
+	pending := len(rs.reminders) == 1 && rs.reminders[0].pending
+	rs.Unlock()
+	if !pending {
+		t.Fatal("reminder going off with the queue full was not left pending")
+	}
+	if len(fd.sent) != 0 {
+		t.Fatalf("sent %d messages with the queue full", len(fd.sent))
+	}
+	deliveryThrottle.release()
+	rs.RetryPending()
+	if len(fd.sent) != 1 {
+		t.Fatalf("retrying sent %d messages, want 1", len(fd.sent))
+	}
+	if len(rs.reminders) != 0 {
+		t.Error("delivered reminder still stored")
+	}
+}
+
+// A goClock is a fakeClock calling the functions of timers in goroutines
+// of their own, as time.AfterFunc does, counting them in wg.
+type goClock struct {
+	*fakeClock
+	wg *sync.WaitGroup
+}
+
+func (c goClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.fakeClock.AfterFunc(d, func() {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			f()
+		}()
+	})
+}
+
+// BenchmarkSimultaneous1000 delivers 1000 reminders going off at once. The
+// throttle keeps its limits but starts deliveries without pausing, so that
+// the benchmark measures queueing rather than waiting.
+func BenchmarkSimultaneous1000(b *testing.B) {
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(concurrentDeliveries, maxQueuedDeliveries, time.Microsecond))()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		c := goClock{newFakeClock(), new(sync.WaitGroup)}
+		rs := newTestState(c)
+		fd := new(fakeDiscord)
+		rs.session = newFakeSession(fd)
+		for k := 0; k < 1000; k++ {
+			r := testReminder(fmt.Sprintf("1000000000000%05d", k%50), fmt.Sprintf("%06x", k), 0, time.Minute)
+			err := rs.Add(r, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		c.Advance(time.Minute)
+		c.wg.Wait()
+		b.StopTimer()
+		if len(fd.sent) != 1000 || len(rs.reminders) != 0 {
+			b.Fatalf("delivered %d of 1000 reminders, %d left", len(fd.sent), len(rs.reminders))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	triggersFilename   = "triggers.csv"
+	defaultReplyWindow = 7 * day
+)
+
+// replyWindow is how long a reminder set with --after-reply waits for a
+// reply before it is dropped, set with REMINDME_REPLY_WINDOW.
+var replyWindow = defaultReplyWindow
+
+// A trigger holds a reminder set with --after-reply until someone replies
+// to the message that set it, which starts the reminder's duration. Until
+// then, the reminder's expiration is when the trigger times out.
+type trigger struct {
+	messageID string
+	duration  time.Duration
+	r         *reminder
+	timer     Timer
+}
+
+// record returns the fields of t in the order of the triggers CSV: the ID
+// of the message awaiting a reply and the duration, followed by the fields
+// of the reminder.
+func (t *trigger) record() []string {
+	return append([]string{t.messageID, t.duration.String()}, t.r.record()...)
+}
+
+// parseTrigger parses a trigger from the fields of a triggers CSV record.
+func parseTrigger(record []string) (*trigger, error) {
+	if len(record) < 2 {
+		return nil, fmt.Errorf("invalid trigger record: %s", record)
+	}
+	d, err := time.ParseDuration(record[1])
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid trigger record: %s", record)
+	}
+	r, err := parseReminder(record[2:])
+	if err != nil {
+		return nil, err
+	}
+	if r.id == "" {
+		return nil, fmt.Errorf("invalid trigger record: %s", record)
+	}
+	return &trigger{messageID: record[0], duration: d, r: r}, nil
+}
+
+// AddTrigger holds r until someone replies to the message with the given
+// ID, then sets it to go off d later. r must be complete but for its ID
+// and expiration. Like Add with a limit, it fails with errTooManyReminders
+// if r's user already has limit reminders, counting those awaiting a
+// reply.
+func (rs *remindmeState) AddTrigger(messageID string, d time.Duration, r *reminder, limit int) error {
+	rs.Lock()
+	defer rs.Unlock()
+	if len(rs.byUser[r.userID])+rs.countTriggers(r.userID) >= limit {
+		return errTooManyReminders
+	}
+	r.id = rs.newID()
+	r.expiration = rs.now().Add(replyWindow)
+	t := &trigger{messageID: messageID, duration: d, r: r}
+	rs.addTrigger(t)
+	rs.appendJournal(append([]string{"trigger"}, t.record()...)...)
+	return nil
+}
+
+// addTrigger adds t, to be dropped at its reminder's expiration if no
+// reply comes by then.
+// The lock must be held.
+func (rs *remindmeState) addTrigger(t *trigger) {
+	if old, ok := rs.triggers[t.messageID]; ok {
+		old.timer.Stop()
+	}
+	if rs.triggers == nil {
+		rs.triggers = make(map[string]*trigger)
+	}
+	rs.triggers[t.messageID] = t
+	t.timer = rs.clock.AfterFunc(rs.until(t.r.expiration), func() {
+		rs.Lock()
+		defer rs.Unlock()
+		if rs.triggers[t.messageID] != t {
+			return
+		}
+		rs.dropTrigger(t)
+		logger.User(t.r.userID).Infof("Dropped reminder %s for %s, as no reply to message %s came in time",
+			t.r.id, t.r.userID, t.messageID)
+	})
+}
+
+// dropTrigger removes t without setting its reminder.
+// The lock must be held.
+func (rs *remindmeState) dropTrigger(t *trigger) {
+	t.timer.Stop()
+	delete(rs.triggers, t.messageID)
+	rs.appendJournal("untrigger", t.messageID)
+}
+
+// countTriggers returns the number of reminders for userID awaiting a
+// reply.
+// The lock must be held.
+func (rs *remindmeState) countTriggers(userID string) int {
+	n := 0
+	for _, t := range rs.triggers {
+		if t.r.userID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// findTrigger returns the trigger holding the reminder with the given id
+// owned by userID, or nil.
+// The lock must be held.
+func (rs *remindmeState) findTrigger(userID string, id string) *trigger {
+	for _, t := range rs.triggers {
+		if t.r.id == id && t.r.ownedBy(userID) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Trigger sets the reminder awaiting a reply to the message with the given
+// ID, if any, to go off after its duration, starting now.
+func (rs *remindmeState) Trigger(messageID string) (reminder, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	t, ok := rs.triggers[messageID]
+	if !ok {
+		return reminder{}, false
+	}
+	r := t.r
+	r.expiration = rs.now().Add(t.duration)
+	rs.insert(r, rs.schedule(r))
+	rs.appendJournal(append([]string{"add"}, r.record()...)...)
+	rs.dropTrigger(t)
+	return *r, true
+}
+
+// setTrigger is setReminder for a reminder set with --after-reply in the
+// message with the given ID, to go off d after the first reply to it.
+func setTrigger(author, target *discordgo.User, messageID string, d time.Duration, r *reminder) (*reminder, error) {
+	err := prepareReminder(author, target, r)
+	if err != nil {
+		return nil, err
+	}
+	err = rmState.AddTrigger(messageID, d, r, maxReminders)
+	if err != nil {
+		return nil, tooManyReminders(author, target)
+	}
+	logger.User(target.ID).Infof("Set reminder %s for %s by %s to go off %s after a reply to message %s with the message %q",
+		r.id, (*userLog)(target), (*userLog)(author), d, messageID, r.message)
+	return r, nil
+}
+
+// replyHandler starts the reminder awaiting a reply to the message m
+// replies to, if any. Replies from bots do not count.
+func replyHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || m.Author.Bot || m.MessageReference == nil {
+		return
+	}
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	r, ok := rmState.Trigger(m.MessageReference.MessageID)
+	if !ok {
+		return
+	}
+	logger.User(r.userID).Infof("Started reminder %s for %s on reply %s from %s, going off %s",
+		r.id, r.userID, m.ID, (*userLog)(m.Author), r.expiration)
+}
+
+// triggerDeleteHandler cancels the reminder awaiting a reply to a message
+// that was deleted, since no reply can come anymore.
+func triggerDeleteHandler(s *discordgo.Session, m *discordgo.MessageDelete) {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	rmState.Lock()
+	defer rmState.Unlock()
+	t, ok := rmState.triggers[m.ID]
+	if !ok {
+		return
+	}
+	rmState.dropTrigger(t)
+	logger.User(t.r.userID).Infof("Dropped reminder %s for %s, as message %s awaiting a reply was deleted",
+		t.r.id, t.r.userID, m.ID)
+}
+
+func (rs *remindmeState) readTriggers(r io.Reader) error {
+	rr := csv.NewReader(r)
+	rr.FieldsPerRecord = -1
+	records, err := rr.ReadAll()
+	if err != nil {
+		return err
+	}
+	triggers := make([]*trigger, len(records))
+	for i, record := range records {
+		triggers[i], err = parseTrigger(record)
+		if err != nil {
+			return err
+		}
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	for _, t := range triggers {
+		rs.addTrigger(t)
+	}
+	return nil
+}
+
+func (rs *remindmeState) writeTriggers(w io.Writer) error {
+	ww := csv.NewWriter(w)
+	rs.Lock()
+	for _, t := range rs.triggers {
+		ww.Write(t.record())
+	}
+	rs.Unlock()
+	ww.Flush()
+	return ww.Error()
+}
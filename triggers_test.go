@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reply returns a message from a user replying to the message messageID.
+func reply(messageID string) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:               "500000000000000009",
+		ChannelID:        "200000000000000001",
+		Author:           &discordgo.User{ID: "100000000000000002"},
+		MessageReference: &discordgo.MessageReference{MessageID: messageID},
+	}}
+}
+
+// awaitReply holds a reminder for userID until a reply to messageID, then
+// sets it to go off d later.
+func awaitReply(t *testing.T, rs *remindmeState, userID, messageID string, d time.Duration) *reminder {
+	t.Helper()
+	r := &reminder{userID: userID, authorID: userID, creation: rs.now(), message: "after " + messageID}
+	err := rs.AddTrigger(messageID, d, r, defaultMaxReminders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTriggerFires(t *testing.T) {
+	c, restore := useTestState(t)
+	defer restore()
+	fd := new(fakeDiscord)
+	rmState.session = newFakeSession(fd)
+	gateway.set(true)
+	defer gateway.set(false)
+	defer useThrottle(newThrottle(1, 1, time.Microsecond))()
+	const userID = "100000000000000001"
+	r := awaitReply(t, &rmState, userID, "300000000000000001", time.Hour)
+	c.Advance(time.Minute)
+	replyHandler(nil, reply("300000000000000001"))
+	if _, ok := rmState.triggers["300000000000000001"]; ok {
+		t.Fatal("trigger kept after the reply")
+	}
+	checkOrder(t, &rmState, []string{r.id})
+	if want := fakeEpoch.Add(time.Minute + time.Hour); !rmState.reminders[0].expiration.Equal(want) {
+		t.Errorf("reminder goes off %v, want an hour after the reply", rmState.reminders[0].expiration)
+	}
+	c.Advance(time.Hour - time.Second)
+	if len(fd.sent) != 0 {
+		t.Fatal("reminder went off before its duration")
+	}
+	c.Advance(time.Second)
+	if len(fd.sent) != 1 || fd.sent[0].Content == "" {
+		t.Fatalf("sent %+v, want the reminder", fd.sent)
+	}
+	if len(rmState.reminders) != 0 {
+		t.Error("delivered reminder still stored")
+	}
+}
+
+func TestTriggerNoMatch(t *testing.T) {
+	c, restore := useTestState(t)
+	defer restore()
+	awaitReply(t, &rmState, "100000000000000001", "300000000000000001", time.Hour)
+	bot := reply("300000000000000001")
+	bot.Author.Bot = true
+	notReply := reply("300000000000000001")
+	notReply.MessageReference = nil
+	for _, m := range []*discordgo.MessageCreate{reply("300000000000000002"), bot, notReply} {
+		replyHandler(nil, m)
+	}
+	if _, ok := rmState.Trigger("300000000000000003"); ok {
+		t.Error("triggered a reminder awaiting a reply to another message")
+	}
+	if len(rmState.reminders) != 0 {
+		t.Fatalf("%d reminders set without a reply to the message", len(rmState.reminders))
+	}
+	if _, ok := rmState.triggers["300000000000000001"]; !ok {
+		t.Fatal("trigger dropped by messages that were not replies to it")
+	}
+	c.Advance(replyWindow)
+	if len(rmState.reminders) != 0 || len(rmState.triggers) != 0 {
+		t.Error("trigger without a reply outlived the reply window")
+	}
+}
+
+func TestTriggerReplay(t *testing.T) {
+	c := newFakeClock()
+	rs := newTestState(c)
+	kept := awaitReply(t, rs, "u", "m1", time.Hour)
+	dropped := awaitReply(t, rs, "u", "m2", 2*time.Hour)
+	fired := awaitReply(t, rs, "u", "m3", 3*time.Hour)
+	bb := new(bytes.Buffer)
+	ww := csv.NewWriter(bb)
+	for _, messageID := range []string{"m1", "m2", "m3"} {
+		ww.Write(append([]string{"trigger"}, rs.triggers[messageID].record()...))
+	}
+	ww.Write([]string{"untrigger", "m2"})
+	fr := *fired
+	fr.expiration = c.Now().Add(3 * time.Hour)
+	ww.Write(append([]string{"add"}, fr.record()...))
+	ww.Write([]string{"untrigger", "m3"})
+	ww.Flush()
+
+	replayed := newTestState(c)
+	err := replayed.replay(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.triggers) != 1 {
+		t.Fatalf("replayed %d triggers, want 1", len(replayed.triggers))
+	}
+	tr := replayed.triggers["m1"]
+	if tr == nil || tr.r.id != kept.id || tr.duration != time.Hour || !tr.r.expiration.Equal(kept.expiration) {
+		t.Fatalf("replayed trigger %+v, want the one awaiting a reply to m1", tr)
+	}
+	if _, ok := replayed.triggers["m2"]; ok {
+		t.Errorf("replayed trigger %s dropped before", dropped.id)
+	}
+	checkOrder(t, replayed, []string{fired.id})
+	// The replayed trigger still fires on a reply.
+	r, ok := replayed.Trigger("m1")
+	if !ok || r.id != kept.id {
+		t.Fatal("replayed trigger did not fire on a reply")
+	}
+	checkOrder(t, replayed, []string{kept.id, fired.id})
+}
+
+func TestTriggerReload(t *testing.T) {
+	c, restore := useTestState(t)
+	defer restore()
+	defer func() {
+		rmState.Lock()
+		rmState.closeJournal()
+		rmState.Unlock()
+	}()
+	err := rmState.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := awaitReply(t, &rmState, "u", "m1", time.Hour)
+	reloadRMState()
+	tr, ok := rmState.triggers["m1"]
+	if !ok || tr.r.id != r.id {
+		t.Fatalf("trigger lost on reload")
+	}
+	c.Advance(replyWindow)
+	if len(rmState.triggers) != 0 {
+		t.Error("reloaded trigger outlived the reply window")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+)
+
+// scheduleWarning starts the timer that warns r's user r.warn before r goes
+// off, replacing any warning already scheduled for r. A warning that would
+// be in the past, as after a restart or a snooze, is skipped, as is one for
+// a pending reminder or a user who has paused their reminders.
+// The lock must be held.
+func (rs *remindmeState) scheduleWarning(r *reminder) {
+	rs.stopWarning(r)
+	if r.warn <= 0 || r.pending || rs.isPaused(r.userID) {
+		return
+	}
+	d := rs.until(r.expiration.Add(-r.warn))
+	if d <= 0 {
+		return
+	}
+	var t Timer
+	t = rs.clock.AfterFunc(d, func() {
+		rs.Lock()
+		current := rs.warnings[r] == t
+		if current {
+			delete(rs.warnings, r)
+		}
+		rs.Unlock()
+		if !current {
+			return
+		}
+		if !rs.startDelivery() {
+			return
+		}
+		defer rs.deliveries.Done()
+		err := deliveryThrottle.acquire(rs.done, r)
+		if err == errQueueFull {
+			logger.User(r.userID).Infof("Skipped warning %s of reminder %s, as too many deliveries are waiting",
+				r.userID, r.id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		err = rs.warn(r)
+		deliveryThrottle.release()
+		if err != nil {
+			logger.User(r.userID).Errorf("unable to warn %s of reminder %s: %v", r.userID, r.id, err)
+			return
+		}
+		logger.User(r.userID).Infof("Warned %s of reminder %s going off %s", r.userID, r.id, r.expiration)
+	})
+	if rs.warnings == nil {
+		rs.warnings = make(map[*reminder]Timer)
+	}
+	rs.warnings[r] = t
+}
+
+// stopWarning stops the warning scheduled for r, if any.
+// The lock must be held.
+func (rs *remindmeState) stopWarning(r *reminder) {
+	if t, ok := rs.warnings[r]; ok {
+		t.Stop()
+		delete(rs.warnings, r)
+	}
+}
+
+// warn privately tells r's user that r is about to go off.
+func (rs *remindmeState) warn(r *reminder) error {
+	s := rs.Session()
+	dm, err := s.UserChannelCreate(r.userID)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("your reminder `%s` goes off %s: %s",
+		r.id, formatUntil(rs.until(r.expiration)), formatMessage(r))
+	_, err = sendChunks(s, dm.ID, content, noMentions)
+	return err
+}
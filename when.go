@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNoTime = errors.New("no time given")
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"sun":       time.Sunday,
+	"monday":    time.Monday,
+	"mon":       time.Monday,
+	"tuesday":   time.Tuesday,
+	"tue":       time.Tuesday,
+	"wednesday": time.Wednesday,
+	"wed":       time.Wednesday,
+	"thursday":  time.Thursday,
+	"thu":       time.Thursday,
+	"friday":    time.Friday,
+	"fri":       time.Friday,
+	"saturday":  time.Saturday,
+	"sat":       time.Saturday,
+}
+
+// parseClock parses a time of day such as "9am", "9:30pm" or "21:00".
+func parseClock(s string) (hour, min int, ok bool) {
+	s = strings.ToLower(s)
+	pm := strings.HasSuffix(s, "pm")
+	twelveHour := pm || strings.HasSuffix(s, "am")
+	if twelveHour {
+		s = s[:len(s)-2]
+	}
+	hourStr, minStr := s, ""
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		hourStr, minStr = s[:i], s[i+1:]
+		if len(minStr) != 2 {
+			return 0, 0, false
+		}
+	} else if !twelveHour {
+		// A bare number is too easily something else.
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil || len(hourStr) > 2 {
+		return 0, 0, false
+	}
+	if minStr != "" {
+		min, err = strconv.Atoi(minStr)
+		if err != nil || min < 0 || min > 59 {
+			return 0, 0, false
+		}
+	}
+	if twelveHour {
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	} else if hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	return hour, min, true
+}
+
+// clockDate is like time.Date, except that a time of day skipped by a
+// daylight saving transition is moved forward by the length of the gap, so
+// that 2:30 on a spring-forward night becomes 3:30.
+func clockDate(year int, month time.Month, day, hour, min int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, min, 0, 0, loc)
+	if t.Hour() == hour && t.Minute() == min {
+		return t
+	}
+	_, before := t.Zone()
+	_, after := t.Add(24 * time.Hour).Zone()
+	return t.Add(time.Duration(after-before) * time.Second)
+}
+
+// nextDaily returns the next time after now, in now's location, at which
+// it is clock, a time of day as accepted by parseClock.
+func nextDaily(clock string, now time.Time) (time.Time, bool) {
+	hour, min, ok := parseClock(clock)
+	if !ok {
+		return time.Time{}, false
+	}
+	year, month, day := now.Date()
+	t := clockDate(year, month, day, hour, min, now.Location())
+	if !t.After(now) {
+		t = clockDate(year, month, day+1, hour, min, now.Location())
+	}
+	return t, true
+}
+
+// parseTime parses an absolute time from the start of words and returns it
+// together with the number of words it took up. now gives the current time
+// in the zone the words are interpreted in. Accepted are:
+//
+//	RFC 3339 timestamps, such as 2024-06-01T09:00:00Z
+//	a date, such as 2024-06-01
+//	today, tomorrow, a weekday, or next followed by a weekday
+//	a time of day, such as 9am, 9:30pm or 21:00
+//
+// A day may be followed by a time of day, optionally introduced by "at";
+// without one, the current time of day is kept. A weekday means the next
+// such day after today. A time of day alone means its next occurrence.
+func parseTime(words []string, now time.Time) (time.Time, int, error) {
+	if len(words) == 0 {
+		return time.Time{}, 0, errNoTime
+	}
+	if t, err := time.Parse(time.RFC3339, words[0]); err == nil {
+		return t, 1, nil
+	}
+	loc := now.Location()
+	year, month, day := now.Date()
+	hour, min, _ := now.Clock()
+	n := 0
+	word := strings.ToLower(words[0])
+	if t, err := time.ParseInLocation("2006-01-02", word, loc); err == nil {
+		year, month, day = t.Date()
+		n = 1
+	} else if word == "today" {
+		n = 1
+	} else if word == "tomorrow" {
+		day++
+		n = 1
+	} else {
+		if word == "next" && len(words) > 1 {
+			word = strings.ToLower(words[1])
+			n = 1
+		}
+		if weekday, ok := weekdays[word]; ok {
+			ahead := int(weekday - now.Weekday())
+			if ahead <= 0 {
+				ahead += 7
+			}
+			day += ahead
+			n++
+		} else {
+			n = 0
+		}
+	}
+	if n == 0 {
+		// Only a time of day.
+		t, ok := nextDaily(words[0], now)
+		if !ok {
+			return time.Time{}, 0, errNoTime
+		}
+		return t, 1, nil
+	}
+	rest := words[n:]
+	if len(rest) > 1 && strings.ToLower(rest[0]) == "at" {
+		if h, m, ok := parseClock(rest[1]); ok {
+			return clockDate(year, month, day, h, m, loc), n + 2, nil
+		}
+	}
+	if len(rest) > 0 {
+		if h, m, ok := parseClock(rest[0]); ok {
+			return clockDate(year, month, day, h, m, loc), n + 1, nil
+		}
+	}
+	return time.Date(year, month, day, hour, min, now.Second(), 0, loc), n, nil
+}
+
+// parseWhen parses when a reminder should go off from the start of words,
+// either as an absolute time understood by parseTime or as a duration from
+// now. It returns the time and the number of words it took up.
+func parseWhen(words []string, now time.Time) (time.Time, int, error) {
+	t, n, err := parseTime(words, now)
+	if err == nil {
+		if !t.After(now) {
+			return time.Time{}, 0, errors.New("that time has already passed")
+		}
+		return t, n, checkDuration(t.Sub(now))
+	}
+	if len(words) == 0 {
+		return time.Time{}, 0, errNoTime
+	}
+	d, err := parseReminderDuration(words[0])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return now.Add(d), 1, nil
+}
+
+// parseRange parses a range of times written as <start> and <end>, each
+// understood by parseTime or written as - to leave that end open, in which
+// case it is returned as the zero time.
+func parseRange(words []string, now time.Time) (start, end time.Time, err error) {
+	bound := func(words []string) (time.Time, int, error) {
+		if len(words) > 0 && words[0] == "-" {
+			return time.Time{}, 1, nil
+		}
+		return parseTime(words, now)
+	}
+	start, n, err := bound(words)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	words = words[n:]
+	if len(words) == 0 || strings.ToLower(words[0]) != "and" {
+		return time.Time{}, time.Time{}, errors.New(`separate the start and end with "and"`)
+	}
+	words = words[1:]
+	end, n, err = bound(words)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if n != len(words) {
+		return time.Time{}, time.Time{}, errors.New("unexpected " + strconv.Quote(strings.Join(words[n:], " ")))
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("the start must be before the end")
+	}
+	return start, end, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in        string
+		hour, min int
+		ok        bool
+	}{
+		{"9am", 9, 0, true},
+		{"9AM", 9, 0, true},
+		{"9:30pm", 21, 30, true},
+		{"12am", 0, 0, true},
+		{"12pm", 12, 0, true},
+		{"12:05am", 0, 5, true},
+		{"21:00", 21, 0, true},
+		{"00:00", 0, 0, true},
+		{"23:59", 23, 59, true},
+		{"24:00", 0, 0, false},
+		{"13pm", 0, 0, false},
+		{"0am", 0, 0, false},
+		{"9", 0, 0, false},
+		{"9:5", 0, 0, false},
+		{"9:60", 0, 0, false},
+		{"9:-1", 0, 0, false},
+		{"100am", 0, 0, false},
+		{"noon", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		hour, min, ok := parseClock(test.in)
+		if ok != test.ok || ok && (hour != test.hour || min != test.min) {
+			t.Errorf("parseClock(%q) = %d, %d, %t; want %d, %d, %t",
+				test.in, hour, min, ok, test.hour, test.min, test.ok)
+		}
+	}
+}
+
+func TestNextDailyDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database: ", err)
+	}
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2021, month, day, hour, min, 0, 0, loc)
+	}
+	// Clocks sprang forward from 2:00 to 3:00 on 2021-03-14 and fell back
+	// from 2:00 to 1:00 on 2021-11-07.
+	tests := []struct {
+		name  string
+		clock string
+		now   time.Time
+		want  time.Time
+	}{{
+		name:  "before spring forward",
+		clock: "09:00",
+		now:   at(time.March, 13, 10, 0),
+		want:  at(time.March, 14, 9, 0),
+	}, {
+		name:  "into the spring-forward gap",
+		clock: "02:30",
+		now:   at(time.March, 13, 23, 0),
+		want:  time.Date(2021, time.March, 14, 7, 30, 0, 0, time.UTC),
+	}, {
+		name:  "after the spring-forward gap",
+		clock: "02:30",
+		now:   at(time.March, 14, 4, 0),
+		want:  at(time.March, 15, 2, 30),
+	}, {
+		name:  "before fall back",
+		clock: "09:00",
+		now:   at(time.November, 6, 10, 0),
+		want:  at(time.November, 7, 9, 0),
+	}, {
+		name:  "into the fall-back overlap",
+		clock: "01:30",
+		now:   at(time.November, 6, 12, 0),
+		want:  time.Date(2021, time.November, 7, 5, 30, 0, 0, time.UTC),
+	}, {
+		name:  "during the fall-back overlap",
+		clock: "01:30",
+		now:   time.Date(2021, time.November, 7, 5, 30, 1, 0, time.UTC).In(loc),
+		want:  at(time.November, 8, 1, 30),
+	}, {
+		name:  "at the repeated hour",
+		clock: "01:30",
+		now:   time.Date(2021, time.November, 7, 6, 0, 0, 0, time.UTC).In(loc),
+		want:  at(time.November, 8, 1, 30),
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := nextDaily(test.clock, test.now)
+			if !ok {
+				t.Fatalf("nextDaily(%q) failed", test.clock)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("nextDaily(%q, %v) = %v, want %v", test.clock, test.now, got, test.want.In(loc))
+			}
+		})
+	}
+}
+
+func TestNextDailyInvalid(t *testing.T) {
+	if _, ok := nextDaily("25:00", fakeEpoch); ok {
+		t.Error("nextDaily accepted an invalid time of day")
+	}
+}
+
+func TestCompleteDailyDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database: ", err)
+	}
+	c := newFakeClock()
+	c.now = time.Date(2021, time.March, 13, 9, 0, 0, 0, loc)
+	rs := newTestState(c)
+	rs.zones = map[string]*time.Location{"u": loc}
+	r := testReminder("u", "d1", 0, 0)
+	r.expiration = c.now.In(time.UTC)
+	r.daily = "09:00"
+	rs.Add(r, 0)
+	// Each day the reminder goes off at 9:00 local time, across the
+	// spring-forward night, which is only 23 hours long.
+	for _, want := range []time.Time{
+		time.Date(2021, time.March, 14, 9, 0, 0, 0, loc),
+		time.Date(2021, time.March, 15, 9, 0, 0, 0, loc),
+	} {
+		rs.Lock()
+		rs.complete(rs.indexByID("d1"))
+		rs.Unlock()
+		checkOrder(t, rs, []string{"d1"})
+		got := rs.reminders[0].expiration
+		if !got.Equal(want) {
+			t.Fatalf("rescheduled to %v, want %v", got.In(loc), want)
+		}
+		c.now = got
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database: ", err)
+	}
+	at := func(month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(2021, month, day, hour, min, sec, 0, loc)
+	}
+	utc := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2021, month, day, hour, min, 0, 0, time.UTC)
+	}
+	// Saturdays before clocks sprang forward from 2:00 to 3:00 on
+	// 2021-03-14 and fell back from 2:00 to 1:00 on 2021-11-07.
+	spring := at(time.March, 13, 10, 15, 30)
+	fall := at(time.November, 6, 12, 0, 0)
+	tests := []struct {
+		words string
+		now   time.Time
+		want  time.Time
+		n     int
+	}{
+		{"tomorrow 9am", spring, at(time.March, 14, 9, 0, 0), 2},
+		{"tomorrow at 9am", spring, at(time.March, 14, 9, 0, 0), 3},
+		{"Tomorrow 9AM call mom", spring, at(time.March, 14, 9, 0, 0), 2},
+		{"tomorrow", spring, at(time.March, 14, 10, 15, 30), 1},
+		{"today 11pm", spring, at(time.March, 13, 23, 0, 0), 2},
+		{"next monday", spring, at(time.March, 15, 10, 15, 30), 2},
+		{"NEXT Monday 9:30pm", spring, at(time.March, 15, 21, 30, 0), 3},
+		{"monday at 21:00", spring, at(time.March, 15, 21, 0, 0), 3},
+		{"saturday", spring, at(time.March, 20, 10, 15, 30), 1},
+		{"sun", spring, at(time.March, 14, 10, 15, 30), 1},
+		{"9am", spring, at(time.March, 14, 9, 0, 0), 1},
+		{"11am", spring, at(time.March, 13, 11, 0, 0), 1},
+		{"2021-04-01", spring, at(time.April, 1, 10, 15, 30), 1},
+		{"2021-04-01 9am", spring, at(time.April, 1, 9, 0, 0), 2},
+		{"2021-03-20T09:00:00Z", spring, utc(time.March, 20, 9, 0), 1},
+		{"2021-03-20T09:00:00+05:30 later", spring, utc(time.March, 20, 3, 30), 1},
+		{"at 9am", spring, time.Time{}, 0},
+		// The day before the spring forward lasts 23 hours; a time in the
+		// skipped hour moves past the gap.
+		{"tomorrow 2:30am", spring, utc(time.March, 14, 7, 30), 2},
+		{"tomorrow 3:30am", spring, utc(time.March, 14, 7, 30), 2},
+		{"tomorrow 1:59am", spring, utc(time.March, 14, 6, 59), 2},
+		{"2:30am", at(time.March, 14, 1, 0, 0), utc(time.March, 14, 7, 30), 1},
+		// The day before the fall back lasts 25 hours; a time in the
+		// repeated hour is its first occurrence.
+		{"tomorrow", fall, utc(time.November, 7, 17, 0), 1},
+		{"tomorrow 1:30am", fall, utc(time.November, 7, 5, 30), 2},
+		{"tomorrow 2:30am", fall, utc(time.November, 7, 7, 30), 2},
+		{"next sunday 9am", fall, utc(time.November, 7, 14, 0), 3},
+	}
+	for _, test := range tests {
+		got, n, err := parseTime(strings.Fields(test.words), test.now)
+		if test.n == 0 {
+			if err == nil {
+				t.Errorf("parseTime(%q) = %v, want an error", test.words, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTime(%q): %v", test.words, err)
+			continue
+		}
+		if !got.Equal(test.want) || n != test.n {
+			t.Errorf("parseTime(%q) = %v, %d; want %v, %d", test.words, got, n, test.want.In(loc), test.n)
+		}
+	}
+}
+
+func TestParseTimeNoTime(t *testing.T) {
+	for _, words := range []string{"", "next", "soon", "next week", "1h", "2021-02-30", "25:00"} {
+		if got, _, err := parseTime(strings.Fields(words), fakeEpoch); err != errNoTime {
+			t.Errorf("parseTime(%q) = %v, %v; want errNoTime", words, got, err)
+		}
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	now := fakeEpoch
+	tests := []struct {
+		words string
+		want  time.Time
+		n     int
+		ok    bool
+	}{
+		{"tomorrow 9am", time.Date(2020, time.January, 2, 9, 0, 0, 0, time.UTC), 2, true},
+		{"next monday", time.Date(2020, time.January, 6, 12, 0, 0, 0, time.UTC), 2, true},
+		{"2020-06-01T09:00:00Z", time.Date(2020, time.June, 1, 9, 0, 0, 0, time.UTC), 1, true},
+		{"2020-06-01T09:00:00-04:00 rent", time.Date(2020, time.June, 1, 13, 0, 0, 0, time.UTC), 1, true},
+		{"1h30m", now.Add(90 * time.Minute), 1, true},
+		{"2d pay rent", now.Add(2 * day), 1, true},
+		{"1pm", now.Add(time.Hour), 1, true},
+		{"today 9am", time.Time{}, 0, false},
+		{"2019-12-31T23:00:00Z", time.Time{}, 0, false},
+		{"2020-01-01T12:00:00Z", time.Time{}, 0, false},
+		{"2099-01-01", time.Time{}, 0, false},
+		{"3y", time.Time{}, 0, false},
+		{"-1h", time.Time{}, 0, false},
+		{"soon", time.Time{}, 0, false},
+		{"", time.Time{}, 0, false},
+	}
+	for _, test := range tests {
+		got, n, err := parseWhen(strings.Fields(test.words), now)
+		if (err == nil) != test.ok {
+			t.Errorf("parseWhen(%q) returned error %v, want error %t", test.words, err, !test.ok)
+			continue
+		}
+		if test.ok && (!got.Equal(test.want) || n != test.n) {
+			t.Errorf("parseWhen(%q) = %v, %d; want %v, %d", test.words, got, n, test.want, test.n)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	// fakeEpoch is a Wednesday at noon.
+	day := func(d, hour int) time.Time {
+		return time.Date(2020, time.January, d, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		words      string
+		start, end time.Time
+		ok         bool
+	}{
+		{"tomorrow and friday 5pm", day(2, 12), day(3, 17), true},
+		{"TOMORROW AND Friday", day(2, 12), day(3, 12), true},
+		{"2020-01-02T00:00:00Z and 2020-01-02T00:00:01Z", day(2, 0), day(2, 0).Add(time.Second), true},
+		{"- and 2020-06-01", time.Time{}, time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC), true},
+		{"tomorrow 9am and -", day(2, 9), time.Time{}, true},
+		{"- and -", time.Time{}, time.Time{}, true},
+		{"friday and tomorrow", time.Time{}, time.Time{}, false},
+		{"tomorrow 9am and tomorrow at 9am", time.Time{}, time.Time{}, false},
+		{"tomorrow friday", time.Time{}, time.Time{}, false},
+		{"tomorrow and", time.Time{}, time.Time{}, false},
+		{"tomorrow and friday later", time.Time{}, time.Time{}, false},
+		{"soon and friday", time.Time{}, time.Time{}, false},
+		{"- - and friday", time.Time{}, time.Time{}, false},
+		{"", time.Time{}, time.Time{}, false},
+	}
+	for _, test := range tests {
+		start, end, err := parseRange(strings.Fields(test.words), fakeEpoch)
+		if (err == nil) != test.ok {
+			t.Errorf("parseRange(%q) returned error %v, want error %t", test.words, err, !test.ok)
+			continue
+		}
+		if test.ok && (!start.Equal(test.start) || !end.Equal(test.end)) {
+			t.Errorf("parseRange(%q) = %v, %v; want %v, %v", test.words, start, end, test.start, test.end)
+		}
+	}
+}